@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/reconcile"
+)
+
+const accountUsageText = `Usage: paysys account [flags] <command> [command flags] <args>
+
+Edit accounts through the database configured in paysys.ini. Every change is
+recorded in account_audit with the --actor name.
+
+Commands:
+  create [-password-stdin] [-secpassword-stdin] [-email addr] <username>
+  show [-json] <username>
+  lock <username>
+  unlock <username>          also clears a brute-force lock
+  set-password [-password-stdin] <username>
+  add-coin <username> <amount>        a negative amount debits
+  set-extpoint <username> <slot> <value>   slot is 1, 2, 4, 5, 6 or 7
+  list [-prefix p] [-locked] [-limit n] [-json]
+  audit [-limit n] [-json] <username>   recorded changes, newest first
+  history [-json] <username>            coin balance over time from the audit log
+  reconcile [-format table|csv|json] [-o file] [-since d] [-window d] [-repair ledger|accept]
+                            compare every coin balance with the coin ledger
+
+Passwords are prompted for on the terminal, or read as one line from stdin
+with -password-stdin.
+
+Flags:
+`
+
+// runAccount implements "paysys account" and returns the exit status
+func runAccount(args []string) int {
+	return runStoreCommand("paysys account", accountUsageText, accountCommands, args)
+}
+
+var accountCommands = map[string]storeCommand{
+	"create":       accountCreate,
+	"show":         accountShow,
+	"lock":         accountLock,
+	"unlock":       accountUnlock,
+	"set-password": accountSetPassword,
+	"add-coin":     accountAddCoin,
+	"set-extpoint": accountSetExtPoint,
+	"list":         accountList,
+	"audit":        accountAudit,
+	"history":      accountHistory,
+	"reconcile":    accountReconcile,
+}
+
+// readPassword reads a password line from stdin, prompting first unless
+// fromStdin is set
+func (cli *storeCLI) readPassword(prompt string, fromStdin bool) (string, error) {
+	if !fromStdin {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	line, err := cli.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	return password, nil
+}
+
+func accountCreate(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin without prompting")
+	secStdin := fs.Bool("secpassword-stdin", false, "read a separate secondary password after the password")
+	email := fs.String("email", "", "email address")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+
+	acc := database.NewAccount{Username: fs.Arg(0), Email: *email}
+	if err := database.ValidateUsername(acc.Username); err != nil {
+		return err
+	}
+	var err error
+	if acc.Password, err = cli.readPassword("Password: ", *passwordStdin); err != nil {
+		return err
+	}
+	if *secStdin {
+		if acc.SecPassword, err = cli.readPassword("", true); err != nil {
+			return err
+		}
+	}
+
+	if err := cli.db.CreateAccount(ctx, cli.actor, acc); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "created account %s\n", acc.Username)
+	return nil
+}
+
+func accountShow(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+
+	acc, err := cli.db.GetAccountInfo(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// Never print password hashes
+	acc.Password, acc.SecPassword = "", ""
+	if *asJSON {
+		return printJSON(cli.out, acc)
+	}
+
+	w := tabwriter.NewWriter(cli.out, 0, 0, 2, ' ', 0)
+	row := func(k string, v any) { fmt.Fprintf(w, "%s:\t%v\n", k, v) }
+	row("id", acc.ID)
+	row("username", acc.Username)
+	row("email", acc.Email)
+	if acc.DateCreate > 0 {
+		row("created", time.Unix(acc.DateCreate, 0).Format(time.DateTime))
+	}
+	row("active", acc.Active)
+	row("locked", acc.Locked)
+	row("newlocked", acc.NewLocked)
+	row("trytohack", acc.TryToHack)
+	if acc.LockedTime != nil {
+		row("locked until", acc.LockedTime.Format(time.DateTime))
+	}
+	row("coin", acc.Coin)
+	row("testcoin", acc.TestCoin)
+	row("lockedCoin", acc.LockedCoin)
+	for _, slot := range database.ExtPointSlots() {
+		row("nExtpoin"+strconv.Itoa(slot), acc.ExtPoints[slot])
+	}
+	row("last login ip", formatIP(acc.LastLoginIP))
+	return w.Flush()
+}
+
+func accountLock(ctx context.Context, cli *storeCLI, args []string) error {
+	return setLocked(ctx, cli, args, true)
+}
+
+func accountUnlock(ctx context.Context, cli *storeCLI, args []string) error {
+	return setLocked(ctx, cli, args, false)
+}
+
+func setLocked(ctx context.Context, cli *storeCLI, args []string, locked bool) error {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+	if err := cli.db.SetLocked(ctx, cli.actor, fs.Arg(0), locked); err != nil {
+		return err
+	}
+	state := "unlocked"
+	if locked {
+		state = "locked"
+	}
+	fmt.Fprintf(cli.out, "%s %s\n", state, fs.Arg(0))
+	return nil
+}
+
+func accountSetPassword(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("set-password", flag.ContinueOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from stdin without prompting")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+	password, err := cli.readPassword("New password: ", *passwordStdin)
+	if err != nil {
+		return err
+	}
+	if err := cli.db.SetPassword(ctx, cli.actor, fs.Arg(0), password); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "password changed for %s\n", fs.Arg(0))
+	return nil
+}
+
+func accountAddCoin(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("add-coin", flag.ContinueOnError)
+	if err := parseCommand(fs, args, 2); err != nil {
+		return err
+	}
+	amount, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+	if err != nil || amount == 0 {
+		return fmt.Errorf("invalid amount %q", fs.Arg(1))
+	}
+	balance, err := cli.db.AddCoin(ctx, cli.actor, fs.Arg(0), amount)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s coin: %d\n", fs.Arg(0), balance)
+	return nil
+}
+
+func accountSetExtPoint(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("set-extpoint", flag.ContinueOnError)
+	if err := parseCommand(fs, args, 3); err != nil {
+		return err
+	}
+	slot, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid slot %q", fs.Arg(1))
+	}
+	value, err := strconv.Atoi(fs.Arg(2))
+	if err != nil {
+		return fmt.Errorf("invalid value %q", fs.Arg(2))
+	}
+	if err := cli.db.SetExtPoint(ctx, cli.actor, fs.Arg(0), slot, value); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s nExtpoin%d: %d\n", fs.Arg(0), slot, value)
+	return nil
+}
+
+func accountList(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only usernames starting with this")
+	locked := fs.Bool("locked", false, "only locked accounts")
+	limit := fs.Int("limit", 100, "maximum rows, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseCommand(fs, args, 0); err != nil {
+		return err
+	}
+
+	accounts, err := cli.db.ListAccounts(ctx, database.AccountFilter{Prefix: *prefix, LockedOnly: *locked, Limit: *limit})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(cli.out, accounts)
+	}
+
+	w := tabwriter.NewWriter(cli.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tACTIVE\tLOCKED\tNEWLOCKED\tTRYTOHACK\tCOIN\tTESTCOIN")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", a.Username, a.Active, a.Locked, a.NewLocked, a.TryToHack, a.Coin, a.TestCoin)
+	}
+	return w.Flush()
+}
+
+func accountAudit(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	limit := fs.Int("limit", 50, "maximum rows, 0 for all")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+
+	records, err := cli.db.AccountAudit(ctx, fs.Arg(0), *limit)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(cli.out, records)
+	}
+
+	w := tabwriter.NewWriter(cli.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTXN\tACTOR\tACTION\tFIELD\tOLD\tNEW")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Time.Format(time.DateTime), shortTxn(r.TxnID), r.Actor, r.Action, r.Field, r.OldValue, r.NewValue)
+	}
+	return w.Flush()
+}
+
+// historyEntry is one coin change in "account history"
+type historyEntry struct {
+	database.AuditRecord
+	Delta int64 `json:"delta"`
+	Gap   bool  `json:"gap"` // OldValue differs from the previous NewValue
+}
+
+// accountHistory replays the coin entries of the audit log. A gap means the
+// balance changed between two recorded changes without an audit row, such
+// as a manual UPDATE.
+func accountHistory(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+	username := fs.Arg(0)
+
+	records, err := cli.db.CoinHistory(ctx, username)
+	if err != nil {
+		return err
+	}
+	current, err := cli.db.GetCoinBalance(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]historyEntry, len(records))
+	for i, r := range records {
+		oldValue, _ := strconv.ParseInt(r.OldValue, 10, 64)
+		newValue, _ := strconv.ParseInt(r.NewValue, 10, 64)
+		entries[i] = historyEntry{AuditRecord: r, Delta: newValue - oldValue}
+		if i > 0 && r.OldValue != records[i-1].NewValue {
+			entries[i].Gap = true
+		}
+	}
+	// The live balance should match the last recorded one
+	unrecorded := len(records) > 0 && strconv.FormatInt(current, 10) != records[len(records)-1].NewValue
+
+	if *asJSON {
+		return printJSON(cli.out, map[string]any{
+			"username":   username,
+			"coin":       current,
+			"history":    entries,
+			"unrecorded": unrecorded,
+		})
+	}
+
+	w := tabwriter.NewWriter(cli.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTXN\tACTOR\tACTION\tDELTA\tBALANCE\t")
+	for _, e := range entries {
+		if e.Gap {
+			fmt.Fprintf(w, "\t\t\t\t\t%s\tunrecorded change\n", e.OldValue)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%+d\t%s\t\n", e.Time.Format(time.DateTime), shortTxn(e.TxnID), e.Actor, e.Action, e.Delta, e.NewValue)
+	}
+	if unrecorded {
+		fmt.Fprintf(w, "\t\t\t\t\t%d\tunrecorded change\n", current)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "current coin: %d\n", current)
+	return nil
+}
+
+// accountReconcile reports accounts whose coin differs from the sum of their
+// coin_ledger entries and suspected double charges, and optionally repairs
+// the drift: "ledger" resets coin to the ledger balance, "accept" records the
+// difference in the ledger instead.
+func accountReconcile(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	format := fs.String("format", "table", "report format: table, csv or json")
+	outPath := fs.String("o", "", "write the report to this file instead of stdout")
+	since := fs.Duration("since", 24*time.Hour, "look for double charges this far back")
+	window := fs.Duration("window", 10*time.Second, "flag identical debits this close together, 0 to skip")
+	repair := fs.String("repair", "", "fix drift: ledger (reset coin) or accept (record drift in the ledger)")
+	if err := parseCommand(fs, args, 0); err != nil {
+		return err
+	}
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	switch *repair {
+	case reconcile.RepairNone, reconcile.RepairLedger, reconcile.RepairAccept:
+	default:
+		return fmt.Errorf("invalid repair mode %q", *repair)
+	}
+
+	report, err := reconcile.Run(ctx, cli.db, reconcile.Options{
+		Since:  time.Now().Add(-*since),
+		Window: *window,
+		Repair: *repair,
+		Actor:  cli.actor,
+	})
+	if err != nil {
+		return err
+	}
+
+	write := func(w io.Writer) error {
+		switch *format {
+		case "csv":
+			return report.WriteCSV(w)
+		case "json":
+			return report.WriteJSON(w)
+		}
+		return writeReconcileTable(w, report)
+	}
+	if *outPath == "" {
+		return write(cli.out)
+	}
+	return writeFile(*outPath, write)
+}
+
+// writeReconcileTable prints a reconciliation report for the terminal
+func writeReconcileTable(out io.Writer, report *reconcile.Report) error {
+	if report.Clean() {
+		_, err := fmt.Fprintln(out, "no drift or double charges found")
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if len(report.Drifts) > 0 {
+		fmt.Fprintln(w, "USERNAME\tCOIN\tLEDGER\tDRIFT\tENTRIES\tREPAIRED\t")
+		for _, d := range report.Drifts {
+			repaired := d.Repaired
+			if d.Error != "" {
+				repaired = "failed: " + d.Error
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%+d\t%d\t%s\t\n", d.Username, d.Coin, d.Expected, d.Drift, d.Entries, repaired)
+		}
+	}
+	if len(report.DoubleCharges) > 0 {
+		if len(report.Drifts) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, "USERNAME\tKIND\tDELTA\tFIRST\tSECOND\tGAP\t")
+		for _, c := range report.DoubleCharges {
+			fmt.Fprintf(w, "%s\t%s\t%d\t#%d %s\t#%d %s\t%s\t\n", c.Username, c.Kind, c.Delta,
+				c.FirstID, c.FirstTime.Format(time.DateTime), c.SecondID, c.SecondTime.Format(time.DateTime),
+				c.SecondTime.Sub(c.FirstTime))
+		}
+	}
+	return w.Flush()
+}
+
+// shortTxn abbreviates a transaction id for table output
+func shortTxn(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// formatIP renders account.LastLoginIP, a network-order IPv4 address read
+// as a little-endian integer
+func formatIP(ip int64) string {
+	if ip == 0 {
+		return "-"
+	}
+	v := uint32(ip)
+	return fmt.Sprintf("%d.%d.%d.%d", byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/database"
+)
+
+// storeCommand is one subcommand of "paysys account" or "paysys recharge"
+type storeCommand func(ctx context.Context, cli *storeCLI, args []string) error
+
+// storeCLI is the state shared by the database subcommands
+type storeCLI struct {
+	cfg   *config.Config
+	db    *database.Connection
+	actor string
+	in    *bufio.Reader
+	out   io.Writer
+}
+
+// errUsage makes runStoreCommand print the usage text and exit with status 2
+var errUsage = errors.New("usage")
+
+// runStoreCommand parses the flags shared by the database subcommands, opens
+// the database and runs the named command. It returns the exit status.
+func runStoreCommand(prog, usage string, commands map[string]storeCommand, args []string) int {
+	fs := flag.NewFlagSet(prog, flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to paysys.ini")
+	dbDSN := fs.String("db-dsn", "", "database DSN, user:password@tcp(host:port)/dbname")
+	actor := fs.String("actor", defaultActor(), "name recorded in the audit log")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", prog, name)
+		fs.Usage()
+		return 2
+	}
+
+	overrides := config.EnvOverrides()
+	setDSN(&overrides, *dbDSN)
+	cfg, err := config.Load(resolveConfigPath(*configPath), overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to load config: %v\n", prog, err)
+		return 1
+	}
+	db, err := database.NewConnection(cfg.Database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prog, err)
+		return 1
+	}
+	defer db.Close()
+
+	cli := &storeCLI{
+		cfg:   cfg,
+		db:    db,
+		actor: "cli:" + *actor,
+		in:    bufio.NewReader(os.Stdin),
+		out:   os.Stdout,
+	}
+	if err := cmd(context.Background(), cli, fs.Args()[1:]); err != nil {
+		if err == errUsage {
+			fs.Usage()
+			return 2
+		}
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", prog, name, err)
+		return 1
+	}
+	return 0
+}
+
+// defaultActor is the operating system user running the command
+func defaultActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// parseCommand parses a subcommand's flags and checks its argument count
+func parseCommand(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != nargs {
+		return errUsage
+	}
+	return nil
+}
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeFile creates path and runs write on it
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"jx2-paysys/internal/config"
+)
+
+// defaultConfigPath is used when neither --config nor PAYSYS_CONFIG is set
+const defaultConfigPath = "paysys.ini"
+
+const usageText = `Usage: paysys [flags]
+       paysys account [flags] <command> ...   (see paysys account -h)
+       paysys recharge [flags] <command> ...  (see paysys recharge -h)
+
+Settings are taken from, lowest to highest precedence:
+  1. built-in defaults
+  2. the INI file (--config, PAYSYS_CONFIG, or ./paysys.ini)
+  3. environment variables:
+       PAYSYS_LISTEN       host:port to accept Bishops on
+       PAYSYS_DB_DSN       user:password@tcp(host:port)/dbname
+       PAYSYS_DB_HOST, PAYSYS_DB_PORT, PAYSYS_DB_USER,
+       PAYSYS_DB_PASSWORD, PAYSYS_DB_NAME
+                           individual database settings, applied after the DSN;
+                           --db-dsn replaces all but PAYSYS_DB_PASSWORD
+       PAYSYS_LOG_LEVEL    debug, info, warn or error
+       PAYSYS_AUTH_MODE    strict, store-fallback or dev-accept-all
+       PAYSYS_ADMIN_TOKEN  bearer token for the admin API
+       PAYSYS_RECHARGE_SECRET
+                           HMAC key for recharge files and the webhook
+  4. the flags below
+
+Flags:
+`
+
+// parseFlags reads the command line and the environment and returns the INI
+// path and the overrides to apply over it
+func parseFlags(args []string) (string, config.Overrides, error) {
+	fs := flag.NewFlagSet("paysys", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to paysys.ini")
+	listen := fs.String("listen", "", "host:port to accept Bishops on")
+	dbDSN := fs.String("db-dsn", "", "database DSN, user:password@tcp(host:port)/dbname")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usageText)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", config.Overrides{}, err
+	}
+
+	overrides := config.EnvOverrides()
+	if *listen != "" {
+		overrides.Listen = *listen
+	}
+	setDSN(&overrides, *dbDSN)
+	if *logLevel != "" {
+		overrides.LogLevel = *logLevel
+	}
+	return resolveConfigPath(*configPath), overrides, nil
+}
+
+// resolveConfigPath applies PAYSYS_CONFIG and the default to the --config
+// value
+func resolveConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if path := os.Getenv(config.EnvConfig); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+// setDSN applies a --db-dsn value. A DSN on the command line replaces the
+// database location and user from the environment; PAYSYS_DB_PASSWORD still
+// applies.
+func setDSN(overrides *config.Overrides, dsn string) {
+	if dsn == "" {
+		return
+	}
+	overrides.DBDSN = dsn
+	overrides.DBHost, overrides.DBPort, overrides.DBUser, overrides.DBName = "", "", "", ""
+}
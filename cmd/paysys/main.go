@@ -1,44 +1,169 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"jx2-paysys/internal/admin"
 	"jx2-paysys/internal/config"
 	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/ipfilter"
+	"jx2-paysys/internal/lockout"
+	"jx2-paysys/internal/logging"
+	"jx2-paysys/internal/playtime"
 	"jx2-paysys/internal/protocol"
+	"jx2-paysys/internal/reconcile"
 	"jx2-paysys/internal/server"
 )
 
+var logger = logging.For("paysys")
+
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig("paysys.ini")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "account":
+			os.Exit(runAccount(os.Args[2:]))
+		case "recharge":
+			os.Exit(runRecharge(os.Args[2:]))
+		}
+	}
+
+	// Load configuration: INI file, then environment, then flags
+	configPath, overrides, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(configPath, overrides)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	// Initialize database connection (optional for testing)
-	var db *database.Connection
-	
-	// Try to connect to database, but don't fail if it's not available
-	db, err = database.NewConnection(cfg.Database)
+	// Configure logging before anything else writes to it
+	level, err := logging.ParseLevel(cfg.Log.Level)
 	if err != nil {
-		log.Printf("Warning: Database connection failed: %v", err)
-		log.Println("Running in no-database mode (all logins will be accepted)")
-		db = nil
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logging.SetLevel(level)
+	logging.SetPacketTrace(cfg.Log.PacketTrace)
+	if cfg.Log.PacketTrace {
+		logger.Warn("packet trace enabled, raw packets are logged at debug level")
+	}
+
+	authMode := cfg.Paysys.AuthMode
+	logger.Info("auth mode", "mode", authMode)
+
+	pingCycle := time.Duration(cfg.Paysys.PingCycle) * time.Second
+	if pingCycle <= 0 {
+		pingCycle = 10 * time.Second
+	}
+
+	// Open the database pool; outside strict mode MySQL does not have to be up
+	// yet, the health monitor keeps reconnecting until it is
+	var db *database.Connection
+	if authMode == config.AuthModeDevAcceptAll {
+		logger.Warn("dev-accept-all mode, every login will be accepted without checking the database")
 	} else {
+		db, err = database.Open(cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
 		defer db.Close()
-		fmt.Printf("[Database] Connected to MySQL at %s:%d\n", cfg.Database.IP, cfg.Database.Port)
+
+		if err := db.Connect(context.Background()); err != nil {
+			if authMode == config.AuthModeStrict {
+				log.Fatalf("Refusing to start in strict auth mode, database unreachable: %v", err)
+			}
+			logger.Warn("database connection failed", "err", err)
+			logger.Warn("store-fallback mode, logins will be accepted until the database becomes reachable")
+		} else {
+			fmt.Printf("[Database] Connected to MySQL at %s:%d\n", cfg.Database.IP, cfg.Database.Port)
+		}
+
+		monitorCtx, stopMonitor := context.WithCancel(context.Background())
+		defer stopMonitor()
+		go db.Monitor(monitorCtx, pingCycle)
+	}
+
+	// Brute-force protection; lockouts are persisted when a database is configured
+	var lockoutStore lockout.Store
+	if db != nil {
+		lockoutStore = db
+	}
+	tracker := lockout.NewTracker(cfg.Lockout, lockoutStore)
+	lockoutCtx, stopLockout := context.WithCancel(context.Background())
+	defer stopLockout()
+	go tracker.Run(lockoutCtx)
+
+	// Daily play-time limit; history comes from account_session when a
+	// database is configured
+	var playTimeStore playtime.Store
+	if db != nil {
+		playTimeStore = db
+	}
+	playTime := playtime.NewTracker(cfg.PlayTime, playTimeStore)
+	if playTime.Enabled() {
+		logger.Info("play-time limit enabled", "online_seconds", cfg.PlayTime.LimitOnlineSecond, "offline_seconds", cfg.PlayTime.LimitOfflineSecond)
+		// Without a decoded logout frame a session only ends with its Bishop,
+		// a kick or the account's next login
+		logger.Warn("player logouts are not tracked, play time counts until the Bishop session ends")
+	}
+
+	// Periodic coin ledger reconciliation, report only
+	var reconciler *reconcile.Job
+	if db != nil {
+		reconciler = reconcile.NewJob(cfg.Reconcile, db)
+		reconcileCtx, stopReconcile := context.WithCancel(context.Background())
+		defer stopReconcile()
+		go reconciler.Run(reconcileCtx)
+	}
+
+	if cfg.Paysys.ProvisionalPackets {
+		logger.Warn("provisional Paysys-initiated packets enabled")
 	}
 
 	// Initialize protocol handler
-	protocolHandler := protocol.NewHandler(db)
+	protocolHandler := protocol.NewHandler(db, protocol.HandlerOptions{
+		AuthMode:           authMode,
+		Lockout:            tracker,
+		PlayTime:           playTime,
+		PingCycle:          pingCycle,
+		MaxMissedPings:     cfg.Paysys.MaxMissedPings,
+		MaxPacketSize:      cfg.Paysys.MaxPacketSize,
+		WriteTimeout:       time.Duration(cfg.Paysys.WriteTimeout) * time.Second,
+		ProvisionalPackets: cfg.Paysys.ProvisionalPackets,
+	})
+	gatewayCtx, stopGateways := context.WithCancel(context.Background())
+	defer stopGateways()
+	go protocolHandler.MonitorGateways(gatewayCtx)
+	go protocolHandler.MonitorPlayTime(gatewayCtx)
+
+	// Only gateways inside InternalIPMask may connect
+	gateways, err := ipfilter.Parse(cfg.Paysys.InternalIPMask)
+	if err != nil {
+		log.Fatalf("Invalid InternalIPMask: %v", err)
+	}
+	if gateways.Empty() {
+		logger.Warn("InternalIPMask is empty, accepting gateway connections from any address")
+	} else {
+		logger.Info("gateway allow-list", "networks", gateways.String())
+	}
 
 	// Create and start the paysys server
-	paysysServer := server.NewPaysysServer(cfg.Paysys.IP, cfg.Paysys.Port, protocolHandler)
+	paysysServer := server.NewPaysysServer(cfg.Paysys.IP, cfg.Paysys.Port, gateways, server.Limits{
+		MaxConnections: cfg.Paysys.MaxConnections,
+		RecvBufSize:    cfg.Paysys.RecvBufSize,
+		SendBufSize:    cfg.Paysys.SendBufSize,
+	}, protocolHandler)
+	registerMetrics(protocolHandler, paysysServer, reconciler)
 
 	// Start server in a goroutine
 	go func() {
@@ -49,11 +174,59 @@ func main() {
 
 	fmt.Printf("[Paysys] Server started on %s:%d\n", cfg.Paysys.IP, cfg.Paysys.Port)
 
+	// Start the optional admin HTTP listener
+	var adminServer *admin.Server
+	if cfg.Admin.Port != 0 {
+		adminAllowed, err := ipfilter.Parse(cfg.Admin.AllowedIPs)
+		if err != nil {
+			log.Fatalf("Invalid admin AllowedIPs: %v", err)
+		}
+		logger.Info("admin allow-list", "networks", adminAllowed.String())
+		adminServer = admin.NewServer(cfg.Admin.IP, cfg.Admin.Port, adminAllowed, protocolHandler, db)
+		adminServer.SetToken(cfg.Admin.Token)
+		adminServer.SetRechargeSecret(cfg.Recharge.Secret)
+		if cfg.Admin.Token == "" {
+			logger.Info("admin API disabled, only /status is served; set [Admin] Token to enable it")
+		}
+		go func() {
+			if err := adminServer.Start(); err != nil {
+				log.Fatalf("Admin server failed to start: %v", err)
+			}
+		}()
+	}
+
+	// Re-read paysys.ini on SIGHUP
+	rl := &reloader{
+		path:      configPath,
+		overrides: overrides,
+		paysys:    paysysServer,
+		handler:   protocolHandler,
+		admin:     adminServer,
+		tracker:   tracker,
+		playTime:  playTime,
+		reconcile: reconciler,
+		running:   cfg,
+	}
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+	go rl.run(reloadCtx)
+
 	// Wait for interrupt signal
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-sigCtx.Done()
+	stopSignals()
 
+	// Drain gateways within the grace period and give up after the hard
+	// timeout; the deferred calls then stop the monitors and close the store
 	fmt.Println("\n[Paysys] Shutting down server...")
-	paysysServer.Stop()
-}
\ No newline at end of file
+	cfg = rl.config()
+	grace := time.Duration(cfg.Paysys.ShutdownGrace) * time.Second
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(cfg.Paysys.ShutdownTimeout)*time.Second)
+	defer cancelShutdown()
+	if adminServer != nil {
+		adminServer.Stop()
+	}
+	if err := paysysServer.Shutdown(shutdownCtx, grace); err != nil {
+		logger.Warn("shutdown did not finish in time", "timeout", cfg.Paysys.ShutdownTimeout, "err", err)
+	}
+}
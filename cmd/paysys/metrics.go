@@ -0,0 +1,44 @@
+package main
+
+import (
+	"jx2-paysys/internal/metrics"
+	"jx2-paysys/internal/protocol"
+	"jx2-paysys/internal/reconcile"
+	"jx2-paysys/internal/server"
+)
+
+// registerMetrics exposes state the handler and listener already track. The
+// per-event counters are declared in the packages that increment them.
+func registerMetrics(handler *protocol.Handler, paysys *server.PaysysServer, reconciler *reconcile.Job) {
+	metrics.NewGaugeFunc("paysys_gateways", "Connected Bishop gateways.",
+		func() float64 { return float64(handler.GatewayCount()) })
+	metrics.NewGaugeFunc("paysys_online_players", "Accounts online through a gateway.",
+		func() float64 { return float64(handler.OnlinePlayerCount()) })
+	metrics.NewGaugeFunc("paysys_db_healthy", "1 while the account database is reachable.",
+		func() float64 {
+			if handler.StoreHealthy() {
+				return 1
+			}
+			return 0
+		})
+	metrics.NewGaugeFunc("paysys_connections_active", "Open gateway connections.",
+		func() float64 { return float64(paysys.ActiveConnections()) })
+	metrics.NewCounterFunc("paysys_connections_rejected_total", "Connections from outside InternalIPMask.",
+		func() float64 { return float64(paysys.RejectedConnections()) })
+	metrics.NewCounterFunc("paysys_connections_refused_total", "Connections refused at MaxConnections.",
+		func() float64 { return float64(paysys.RefusedConnections()) })
+	metrics.NewGaugeFunc("paysys_coin_drift_accounts", "Accounts whose coin differed from the ledger at the last reconciliation.",
+		func() float64 {
+			if last := reconciler.Last(); last != nil {
+				return float64(len(last.Drifts))
+			}
+			return 0
+		})
+	metrics.NewGaugeFunc("paysys_coin_double_charges", "Suspected double charges found by the last reconciliation.",
+		func() float64 {
+			if last := reconciler.Last(); last != nil {
+				return float64(len(last.DoubleCharges))
+			}
+			return 0
+		})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"jx2-paysys/internal/recharge"
+)
+
+const rechargeUsageText = `Usage: paysys recharge [flags] <command> [command flags] <args>
+
+Credit web shop orders through the coin ledger. Files are signed with the
+hex HMAC-SHA256 of their content under [Recharge] Secret; an order id is
+credited at most once, so a file can be imported again safely.
+
+Commands:
+  import [-sig file] [-format table|csv|json] [-o file] <orders.csv|orders.json>
+                            signature is read from <file>.sig unless -sig is given
+
+Flags:
+`
+
+// runRecharge implements "paysys recharge" and returns the exit status
+func runRecharge(args []string) int {
+	return runStoreCommand("paysys recharge", rechargeUsageText, rechargeCommands, args)
+}
+
+var rechargeCommands = map[string]storeCommand{
+	"import": rechargeImport,
+}
+
+// rechargeImport verifies a recharge file's signature and credits its
+// orders. It fails when any order was rejected, after writing the report.
+func rechargeImport(ctx context.Context, cli *storeCLI, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	sigPath := fs.String("sig", "", "signature file, default <file>.sig")
+	format := fs.String("format", "table", "report format: table, csv or json")
+	outPath := fs.String("o", "", "write the report to this file instead of stdout")
+	if err := parseCommand(fs, args, 1); err != nil {
+		return err
+	}
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	path := fs.Arg(0)
+	if *sigPath == "" {
+		*sigPath = path + ".sig"
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	signature, err := os.ReadFile(*sigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+	if err := recharge.Verify(cli.cfg.Recharge.Secret, data, string(signature)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	orders, err := recharge.ParseFile(path, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	report := recharge.Import(ctx, cli.db, cli.actor, path, orders)
+	write := func(w io.Writer) error {
+		switch *format {
+		case "csv":
+			return report.WriteCSV(w)
+		case "json":
+			return report.WriteJSON(w)
+		}
+		return writeRechargeTable(w, report)
+	}
+	if *outPath == "" {
+		err = write(cli.out)
+	} else {
+		err = writeFile(*outPath, write)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Rejected > 0 {
+		return fmt.Errorf("%d of %d orders rejected", report.Rejected, len(report.Results))
+	}
+	return nil
+}
+
+// writeRechargeTable prints an import report for the terminal
+func writeRechargeTable(out io.Writer, report *recharge.Report) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tORDER\tACCOUNT\tAMOUNT\tSTATUS\tCOIN\t")
+	for _, r := range report.Results {
+		coin := r.Reason
+		if r.Status == recharge.StatusApplied {
+			coin = fmt.Sprint(r.Balance)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t\n", r.Line, r.OrderID, r.Username, r.Amount, r.Status, coin)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%d applied, %d duplicate, %d rejected, %d coin credited\n",
+		report.Applied, report.Duplicates, report.Rejected, report.Coin)
+	return err
+}
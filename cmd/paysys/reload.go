@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"jx2-paysys/internal/admin"
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/ipfilter"
+	"jx2-paysys/internal/lockout"
+	"jx2-paysys/internal/logging"
+	"jx2-paysys/internal/playtime"
+	"jx2-paysys/internal/protocol"
+	"jx2-paysys/internal/reconcile"
+	"jx2-paysys/internal/server"
+)
+
+// reloader re-reads paysys.ini on SIGHUP and applies the settings that can
+// change without dropping the Bishops
+type reloader struct {
+	path      string
+	overrides config.Overrides // environment and flags, re-applied on every reload
+	paysys    *server.PaysysServer
+	handler   *protocol.Handler
+	admin     *admin.Server // nil when the admin listener is disabled
+	tracker   *lockout.Tracker
+	playTime  *playtime.Tracker
+	reconcile *reconcile.Job // nil without an account database
+
+	mu      sync.Mutex
+	running *config.Config // settings in effect
+}
+
+// config returns the settings in effect
+func (r *reloader) config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running
+}
+
+// run reloads on every SIGHUP until ctx is cancelled
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := r.reload(); err != nil {
+				logger.Error("config reload failed, keeping current settings", "path", r.path, "err", err)
+			}
+		}
+	}
+}
+
+// reload parses the file and applies it. Nothing is applied unless the
+// whole file is valid.
+func (r *reloader) reload() error {
+	next, err := config.Load(r.path, r.overrides)
+	if err != nil {
+		return err
+	}
+	level, err := logging.ParseLevel(next.Log.Level)
+	if err != nil {
+		return err
+	}
+	gateways, err := ipfilter.Parse(next.Paysys.InternalIPMask)
+	if err != nil {
+		return fmt.Errorf("invalid InternalIPMask: %w", err)
+	}
+	adminAllowed, err := ipfilter.Parse(next.Admin.AllowedIPs)
+	if err != nil {
+		return fmt.Errorf("invalid admin AllowedIPs: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev := r.running
+
+	logging.SetLevel(level)
+	logging.SetPacketTrace(next.Log.PacketTrace)
+	r.paysys.SetGateways(gateways)
+	r.handler.SetProvisionalPackets(next.Paysys.ProvisionalPackets)
+	r.paysys.SetLimits(server.Limits{
+		MaxConnections: next.Paysys.MaxConnections,
+		RecvBufSize:    next.Paysys.RecvBufSize,
+		SendBufSize:    next.Paysys.SendBufSize,
+	})
+	if r.admin != nil {
+		r.admin.SetAllowed(adminAllowed)
+		r.admin.SetToken(next.Admin.Token)
+		r.admin.SetRechargeSecret(next.Recharge.Secret)
+	}
+	r.tracker.SetConfig(next.Lockout)
+	r.playTime.SetConfig(next.PlayTime)
+	if r.reconcile != nil {
+		r.reconcile.SetConfig(next.Reconcile)
+	}
+
+	// Keep running values for everything that needs a restart so the next
+	// reload reports them again until it happens
+	applied := *prev
+	applied.Log = next.Log
+	applied.Lockout = next.Lockout
+	applied.PlayTime = next.PlayTime
+	applied.Reconcile = next.Reconcile
+	applied.Paysys.InternalIPMask = next.Paysys.InternalIPMask
+	applied.Paysys.ProvisionalPackets = next.Paysys.ProvisionalPackets
+	applied.Paysys.MaxConnections = next.Paysys.MaxConnections
+	applied.Paysys.RecvBufSize = next.Paysys.RecvBufSize
+	applied.Paysys.SendBufSize = next.Paysys.SendBufSize
+	applied.Paysys.ShutdownGrace = next.Paysys.ShutdownGrace
+	applied.Paysys.ShutdownTimeout = next.Paysys.ShutdownTimeout
+	applied.Admin.AllowedIPs = next.Admin.AllowedIPs
+	applied.Admin.Token = next.Admin.Token
+	applied.Recharge = next.Recharge
+	r.running = &applied
+
+	logger.Info("config reloaded",
+		"path", r.path,
+		"log_level", next.Log.Level,
+		"gateways", gateways.String(),
+		"admin_allowed", adminAllowed.String(),
+		"max_connections", next.Paysys.MaxConnections)
+	if pending := restartRequired(prev, next); len(pending) > 0 {
+		logger.Warn("changed settings need a restart to take effect", "settings", pending)
+	}
+	return nil
+}
+
+// restartRequired lists settings that differ between the running and the
+// reloaded configuration but can only be applied by a restart
+func restartRequired(running, next *config.Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if a != b {
+			changed = append(changed, name)
+		}
+	}
+
+	check("Paysys.IP", running.Paysys.IP, next.Paysys.IP)
+	check("Paysys.Port", running.Paysys.Port, next.Paysys.Port)
+	check("Paysys.AuthMode", running.Paysys.AuthMode, next.Paysys.AuthMode)
+	check("Paysys.PingCycle", running.Paysys.PingCycle, next.Paysys.PingCycle)
+	check("Paysys.MaxMissedPings", running.Paysys.MaxMissedPings, next.Paysys.MaxMissedPings)
+	check("Paysys.MaxPacketSize", running.Paysys.MaxPacketSize, next.Paysys.MaxPacketSize)
+	check("Paysys.WriteTimeout", running.Paysys.WriteTimeout, next.Paysys.WriteTimeout)
+	check("Paysys.LocalIP", running.Paysys.LocalIP, next.Paysys.LocalIP)
+
+	// Any change to the DSN or the pool means reopening the store
+	a, b := running.Database, next.Database
+	if a.IP != b.IP || a.Port != b.Port || a.UserName != b.UserName || a.Password != b.Password || a.DBName != b.DBName {
+		changed = append(changed, "Database DSN")
+	}
+	check("Database.MaxOpenConns", a.MaxOpenConns, b.MaxOpenConns)
+	check("Database.MaxIdleConns", a.MaxIdleConns, b.MaxIdleConns)
+	check("Database.ConnMaxLifetime", a.ConnMaxLifetime, b.ConnMaxLifetime)
+	check("Database.QueryTimeout", a.QueryTimeout, b.QueryTimeout)
+	check("Database.ReconcileTimeout", a.ReconcileTimeout, b.ReconcileTimeout)
+	check("Database.PasswordHash", a.PasswordHash, b.PasswordHash)
+
+	check("Admin.IP", running.Admin.IP, next.Admin.IP)
+	check("Admin.Port", running.Admin.Port, next.Admin.Port)
+	return changed
+}
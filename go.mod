@@ -3,3 +3,8 @@ module jx2-paysys
 go 1.21
 
 require github.com/go-sql-driver/mysql v1.7.1
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0 // indirect
+)
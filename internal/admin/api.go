@@ -0,0 +1,280 @@
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/protocol"
+)
+
+// maxRequestBody bounds JSON request bodies
+const maxRequestBody = 4096
+
+// apiRoutes returns the /api/ handler:
+//
+//	GET  /api/gateways
+//	GET  /api/players[?gateway=<session id>]
+//	GET  /api/accounts/<username>
+//	POST /api/accounts/<username>/coin    {"amount": n}
+//	POST /api/accounts/<username>/lock
+//	POST /api/accounts/<username>/unlock
+//	POST /api/accounts/<username>/kick
+func (s *Server) apiRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/gateways", s.handleGateways)
+	mux.HandleFunc("/api/players", s.handlePlayers)
+	mux.HandleFunc("/api/accounts/", s.handleAccount)
+	return mux
+}
+
+// gatewayResponse describes one connected Bishop
+type gatewayResponse struct {
+	ID           string    `json:"id"`
+	BishopID     string    `json:"bishop_id"`
+	StartTime    time.Time `json:"start_time"`
+	LastActivity time.Time `json:"last_activity"`
+	LastPing     time.Time `json:"last_ping"`
+	Players      int       `json:"players"`
+}
+
+// playerResponse describes one online account
+type playerResponse struct {
+	Username  string    `json:"username"`
+	Gateway   string    `json:"gateway"`
+	Addr      string    `json:"addr"`
+	LoginTime time.Time `json:"login_time"`
+}
+
+func newPlayerResponse(p protocol.OnlinePlayer) playerResponse {
+	return playerResponse{Username: p.Username, Gateway: p.SessionID, Addr: p.Addr, LoginTime: p.LoginTime}
+}
+
+// accountResponse is an account without its password hashes, plus its
+// online entry
+type accountResponse struct {
+	*database.AccountInfo
+	Password    string          `json:"password,omitempty"`
+	SecPassword string          `json:"secpassword,omitempty"`
+	Online      *playerResponse `json:"online"`
+	PlayTime    int64           `json:"play_time"` // Seconds counted toward today's play-time limit
+}
+
+func (s *Server) handleGateways(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, p := range s.handler.GetOnlinePlayers() {
+		counts[p.SessionID]++
+	}
+	gateways := []gatewayResponse{}
+	for id, session := range s.handler.GetActiveBishopSessions() {
+		gateways = append(gateways, gatewayResponse{
+			ID:           id,
+			BishopID:     hex.EncodeToString(session.BishopID[:]),
+			StartTime:    session.StartTime,
+			LastActivity: session.LastActivity,
+			LastPing:     session.LastPing,
+			Players:      counts[id],
+		})
+	}
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].ID < gateways[j].ID })
+	writeJSON(w, http.StatusOK, gateways)
+}
+
+func (s *Server) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	gateway := r.URL.Query().Get("gateway")
+	players := []playerResponse{}
+	for _, p := range s.handler.GetOnlinePlayers() {
+		if gateway == "" || p.SessionID == gateway {
+			players = append(players, newPlayerResponse(p))
+		}
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Username < players[j].Username })
+	writeJSON(w, http.StatusOK, players)
+}
+
+// handleAccount routes /api/accounts/<username>[/<action>]
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	username, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/accounts/"), "/")
+	if database.ValidateUsername(username) != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.handleAccountLookup(w, r, username)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch action {
+	case "coin":
+		s.handleAccountCoin(w, r, username)
+	case "lock":
+		s.handleAccountLock(w, r, username, true)
+	case "unlock":
+		s.handleAccountLock(w, r, username, false)
+	case "kick":
+		s.handleAccountKick(w, r, username)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) handleAccountLookup(w http.ResponseWriter, r *http.Request, username string) {
+	if !s.requireStore(w) {
+		return
+	}
+	acc, err := s.db.GetAccountInfo(r.Context(), username)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	resp := accountResponse{AccountInfo: acc, PlayTime: int64(s.handler.PlayTime(username) / time.Second)}
+	if p, online := s.handler.FindOnlinePlayer(username); online {
+		player := newPlayerResponse(p)
+		resp.Online = &player
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// coinRequest is the body of POST /api/accounts/<username>/coin
+type coinRequest struct {
+	Amount int64 `json:"amount"` // Negative to debit
+}
+
+func (s *Server) handleAccountCoin(w http.ResponseWriter, r *http.Request, username string) {
+	var req coinRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Amount == 0 {
+		writeError(w, http.StatusBadRequest, "amount must not be zero")
+		return
+	}
+	if !s.requireStore(w) {
+		return
+	}
+
+	balance, err := s.db.AddCoin(r.Context(), actor(r), username, req.Amount)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.Info("coin adjusted", "username", username, "amount", req.Amount, "coin", balance, "addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"username": username, "coin": balance})
+}
+
+// handleAccountLock locks or unlocks an account. Locking also kicks the
+// player if online.
+func (s *Server) handleAccountLock(w http.ResponseWriter, r *http.Request, username string, locked bool) {
+	if !s.requireStore(w) {
+		return
+	}
+	if err := s.db.SetLocked(r.Context(), actor(r), username, locked); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	logger.Info("account lock changed", "username", username, "locked", locked, "addr", r.RemoteAddr)
+
+	kicked := false
+	if locked {
+		err := s.handler.KickPlayer(r.Context(), username, protocol.KickReasonBanned)
+		switch {
+		case err == nil:
+			kicked = true
+		case !errors.Is(err, protocol.ErrPlayerOffline) && !errors.Is(err, protocol.ErrProvisionalDisabled):
+			logger.Warn("failed to kick locked account", "username", username, "err", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"username": username, "locked": locked, "kicked": kicked})
+}
+
+func (s *Server) handleAccountKick(w http.ResponseWriter, r *http.Request, username string) {
+	err := s.handler.KickPlayer(r.Context(), username, protocol.KickReasonAdmin)
+	switch {
+	case errors.Is(err, protocol.ErrPlayerOffline):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, protocol.ErrProvisionalDisabled):
+		writeError(w, http.StatusConflict, "kick frame disabled, set [Paysys] ProvisionalPackets=1")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	logger.Info("player kicked by admin", "username", username, "addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"username": username, "kicked": true})
+}
+
+// requireStore answers 503 when there is no usable account store
+func (s *Server) requireStore(w http.ResponseWriter) bool {
+	if s.db == nil {
+		writeError(w, http.StatusServiceUnavailable, "no account database in this auth mode")
+		return false
+	}
+	return true
+}
+
+// writeStoreError maps database errors to HTTP status codes
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrAccountNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, database.ErrInsufficientCoin):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, database.ErrUnavailable):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		logger.Error("admin store call failed", "err", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// readJSON decodes a bounded request body into v, answering 400 on failure
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// actor names an API client in the audit log
+func actor(r *http.Request) string {
+	return "api:" + remoteHost(r)
+}
+
+// remoteHost is the client address without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"jx2-paysys/internal/protocol"
+	"jx2-paysys/internal/recharge"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of a webhook body under
+// [Recharge] Secret, optionally prefixed with "sha256="
+const SignatureHeader = "X-Paysys-Signature"
+
+// rechargeResponse is the body of a successful POST /recharge
+type rechargeResponse struct {
+	OrderID  string `json:"order_id"`
+	Status   string `json:"status"`
+	Coin     int64  `json:"coin,omitempty"`
+	Notified bool   `json:"notified"` // Balance pushed to the player's Bishop
+	// NotifySkipped says why no notice was sent: "disabled" without
+	// [Paysys] ProvisionalPackets, "offline" when the player is not online
+	NotifySkipped string `json:"notify_skipped,omitempty"`
+	NotifyError   string `json:"notify_error,omitempty"` // The notice failed to queue
+}
+
+// setNotifyResult records the outcome of the coin balance notice in resp
+func setNotifyResult(resp *rechargeResponse, err error) {
+	switch {
+	case err == nil:
+		resp.Notified = true
+	case errors.Is(err, protocol.ErrProvisionalDisabled):
+		resp.NotifySkipped = "disabled"
+	case errors.Is(err, protocol.ErrPlayerOffline):
+		resp.NotifySkipped = "offline"
+	default:
+		resp.NotifyError = err.Error()
+	}
+}
+
+// SetRechargeSecret replaces the webhook signing key. An empty secret
+// disables /recharge.
+func (s *Server) SetRechargeSecret(secret string) {
+	s.rechargeSecret.Store(&secret)
+}
+
+// handleRecharge credits one shop order:
+//
+//	POST /recharge  {"order_id": "...", "account": "...", "amount": n}
+//
+// The body must be signed in SignatureHeader. A repeated order id answers 200
+// with status "duplicate" so the shop stops retrying; only 5xx answers should
+// be retried.
+func (s *Server) handleRecharge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	secret := *s.rechargeSecret.Load()
+	if secret == "" {
+		writeError(w, http.StatusForbidden, "recharge disabled, set [Recharge] Secret")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body: "+err.Error())
+		return
+	}
+	if err := recharge.Verify(secret, body, r.Header.Get(SignatureHeader)); err != nil {
+		logger.Warn("rejected recharge with bad signature", "addr", r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var order recharge.Order
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&order); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if !s.requireStore(w) {
+		return
+	}
+
+	res := recharge.Credit(r.Context(), s.db, "shop:"+remoteHost(r), order)
+	switch res.Status {
+	case recharge.StatusApplied:
+		resp := rechargeResponse{OrderID: order.OrderID, Status: res.Status, Coin: res.Balance}
+		err := s.handler.NotifyCoinBalance(order.Username, res.Balance)
+		setNotifyResult(&resp, err)
+		if resp.NotifyError != "" {
+			logger.Warn("failed to notify Bishop of recharge", "username", order.Username, "err", err)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case recharge.StatusDuplicate:
+		writeJSON(w, http.StatusOK, rechargeResponse{OrderID: order.OrderID, Status: res.Status})
+	case recharge.StatusUnknownAccount:
+		writeError(w, http.StatusNotFound, res.Reason)
+	case recharge.StatusInvalid:
+		writeError(w, http.StatusBadRequest, res.Reason)
+	default:
+		writeError(w, http.StatusServiceUnavailable, "recharge failed, retry later")
+	}
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"jx2-paysys/internal/protocol"
+	"jx2-paysys/internal/recharge"
+)
+
+func TestRechargeSignature(t *testing.T) {
+	const secret = "shop-secret"
+	body := `{"order_id":"SHOP-1","account":"alice","amount":100}`
+	valid := recharge.Sign(secret, []byte(body))
+
+	// Without a database a request that passes the signature check stops at
+	// the store with 503, so that status means the signature was accepted
+	tests := []struct {
+		name      string
+		secret    string
+		method    string
+		body      string
+		signature string
+		want      int
+	}{
+		{"valid signature", secret, http.MethodPost, body, valid, http.StatusServiceUnavailable},
+		{"sha256 prefix and spaces", secret, http.MethodPost, body, " sha256=" + valid + " ", http.StatusServiceUnavailable},
+		{"upper-case hex", secret, http.MethodPost, body, strings.ToUpper(valid), http.StatusServiceUnavailable},
+		{"missing signature", secret, http.MethodPost, body, "", http.StatusUnauthorized},
+		{"not hex", secret, http.MethodPost, body, "zz" + valid[2:], http.StatusUnauthorized},
+		{"truncated signature", secret, http.MethodPost, body, valid[:32], http.StatusUnauthorized},
+		{"other secret", secret, http.MethodPost, body, recharge.Sign("other", []byte(body)), http.StatusUnauthorized},
+		{"tampered body", secret, http.MethodPost, strings.Replace(body, "100", "1000", 1), valid, http.StatusUnauthorized},
+		{"recharge disabled", "", http.MethodPost, body, valid, http.StatusForbidden},
+		{"wrong method", secret, http.MethodGet, body, valid, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			s.SetRechargeSecret(tt.secret)
+
+			req := httptest.NewRequest(tt.method, "/recharge", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(SignatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			s.handleRecharge(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.want, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestRechargeNotifyResult(t *testing.T) {
+	h := protocol.NewHandler(nil, protocol.HandlerOptions{})
+	tests := []struct {
+		name        string
+		provisional bool
+		wantSkipped string
+	}{
+		{"provisional packets off", false, "disabled"},
+		{"player offline", true, "offline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.SetProvisionalPackets(tt.provisional)
+			var resp rechargeResponse
+			setNotifyResult(&resp, h.NotifyCoinBalance("alice", 500))
+			if resp.Notified || resp.NotifySkipped != tt.wantSkipped || resp.NotifyError != "" {
+				t.Errorf("notified=%v skipped=%q error=%q, want false, %q and no error",
+					resp.Notified, resp.NotifySkipped, resp.NotifyError, tt.wantSkipped)
+			}
+		})
+	}
+
+	var resp rechargeResponse
+	setNotifyResult(&resp, protocol.ErrSendQueueFull)
+	if resp.NotifyError == "" || resp.NotifySkipped != "" {
+		t.Errorf("send failure: skipped=%q error=%q, want an error only", resp.NotifySkipped, resp.NotifyError)
+	}
+}
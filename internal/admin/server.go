@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/ipfilter"
+	"jx2-paysys/internal/logging"
+	"jx2-paysys/internal/metrics"
+	"jx2-paysys/internal/protocol"
+)
+
+var logger = logging.For("admin")
+
+// Server exposes paysys state over HTTP for operators
+type Server struct {
+	ip             string
+	port           int
+	allowed        atomic.Pointer[ipfilter.Filter]
+	token          atomic.Pointer[[sha256.Size]byte] // nil disables /api/
+	rechargeSecret atomic.Pointer[string]            // empty disables /recharge
+	handler        *protocol.Handler
+	db             *database.Connection // nil in dev-accept-all mode
+	httpServer     *http.Server
+	rejected       atomic.Uint64
+}
+
+// NewServer creates a new admin HTTP server instance. Only clients inside
+// allowed (the [Admin] AllowedIPs list) may use it; unlike InternalIPMask an
+// empty list admits nobody. db may be nil, in which
+// case the account endpoints answer 503.
+func NewServer(ip string, port int, allowed *ipfilter.Filter, handler *protocol.Handler, db *database.Connection) *Server {
+	s := &Server{
+		ip:      ip,
+		port:    port,
+		handler: handler,
+		db:      db,
+	}
+	s.SetAllowed(allowed)
+	s.SetRechargeSecret("")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/recharge", s.handleRecharge)
+	mux.Handle("/api/", s.authenticate(s.apiRoutes()))
+
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", ip, port),
+		Handler:           s.filter(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Start starts the admin server and blocks until it is stopped
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.httpServer.Addr, err)
+	}
+	logger.Info("listening", "addr", s.httpServer.Addr)
+
+	if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Stop stops the admin server
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		logger.Warn("shutdown error", "err", err)
+	}
+}
+
+// SetAllowed replaces the admin allow-list
+func (s *Server) SetAllowed(allowed *ipfilter.Filter) {
+	s.allowed.Store(allowed)
+}
+
+// SetToken replaces the API bearer token. An empty token disables /api/.
+func (s *Server) SetToken(token string) {
+	if token == "" {
+		s.token.Store(nil)
+		return
+	}
+	sum := sha256.Sum256([]byte(token))
+	s.token.Store(&sum)
+}
+
+// RejectedRequests returns how many requests were refused by the allow-list
+func (s *Server) RejectedRequests() uint64 {
+	return s.rejected.Load()
+}
+
+// filter rejects requests from outside the admin allow-list. An empty
+// ipfilter.Filter allows everything, so it is refused here instead.
+func (s *Server) filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := s.allowed.Load()
+		if allowed.Empty() || !allowed.Allow(r.RemoteAddr) {
+			total := s.rejected.Add(1)
+			logger.Warn("rejected admin request outside AllowedIPs", "addr", r.RemoteAddr, "path", r.URL.Path, "rejected_total", total)
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate requires "Authorization: Bearer <token>" matching [Admin] Token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := s.token.Load()
+		if want == nil {
+			writeError(w, http.StatusForbidden, "admin API disabled, set [Admin] Token")
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		sum := sha256.Sum256([]byte(got))
+		if !ok || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+			logger.Warn("rejected admin request with bad token", "addr", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusResponse is the body of GET /status
+type statusResponse struct {
+	AuthMode        string `json:"auth_mode"`
+	DatabaseHealthy bool   `json:"database_healthy"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{
+		AuthMode:        s.handler.AuthMode(),
+		DatabaseHealthy: s.handler.StoreHealthy(),
+	})
+}
+
+// handleMetrics serves every registered metric in the Prometheus text
+// format. Like /status it is guarded by AllowedIPs only, so scrapers need no
+// token.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.Default.WriteText(w); err != nil {
+		logger.Warn("failed to write metrics", "err", err)
+	}
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("failed to encode response", "err", err)
+	}
+}
+
+// writeError writes a JSON error body
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"jx2-paysys/internal/ipfilter"
+)
+
+func TestFilterFailsClosed(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		allowed string
+		addr    string
+		want    int
+	}{
+		{"inside list", "127.0.0.1/32", "127.0.0.1:5000", http.StatusOK},
+		{"outside list", "127.0.0.1/32", "10.0.0.1:5000", http.StatusForbidden},
+		{"empty list admits nobody", "", "127.0.0.1:5000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := ipfilter.Parse(tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{}
+			s.SetAllowed(allowed)
+
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			req.RemoteAddr = tt.addr
+			rec := httptest.NewRecorder()
+			s.filter(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	s := &Server{}
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	rec := httptest.NewRecorder()
+	s.filter(ok).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("unset allow-list: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -5,21 +5,50 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"jx2-paysys/internal/logging"
+)
+
+var logger = logging.For("config")
+
+// Auth modes selecting what happens to logins when storage is unavailable
+const (
+	AuthModeStrict        = "strict"         // Reject logins without a healthy database
+	AuthModeDevAcceptAll  = "dev-accept-all" // Never consult the database (lab use only)
+	AuthModeStoreFallback = "store-fallback" // Use the database, accept all while it is down
 )
 
 // Config represents the entire configuration
 type Config struct {
-	Paysys   PaysysConfig
-	Database DatabaseConfig
+	Paysys    PaysysConfig
+	Database  DatabaseConfig
+	Admin     AdminConfig
+	Lockout   LockoutConfig
+	Reconcile ReconcileConfig
+	Recharge  RechargeConfig
+	PlayTime  PlayTimeConfig
+	Log       LogConfig
 }
 
 // PaysysConfig represents paysys server configuration
 type PaysysConfig struct {
-	IP               string
-	Port             int
-	PingCycle        int
-	InternalIPMask   string
-	LocalIP          string
+	IP              string
+	Port            int
+	PingCycle       int
+	MaxMissedPings  int
+	MaxConnections  int
+	RecvBufSize     int
+	SendBufSize     int
+	MaxPacketSize   int
+	WriteTimeout    int
+	ShutdownGrace   int
+	ShutdownTimeout int
+	InternalIPMask  string
+	LocalIP         string
+	AuthMode        string
+	// ProvisionalPackets enables Paysys-initiated frames whose layout has not
+	// been checked against a capture (automatic kicks, coin notices)
+	ProvisionalPackets bool
 }
 
 // DatabaseConfig represents database configuration
@@ -29,78 +58,266 @@ type DatabaseConfig struct {
 	UserName string
 	Password string
 	DBName   string
+
+	// Connection pool tuning (0 keeps the database/sql default)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime int // Seconds
+	QueryTimeout    int // Seconds, applied to every store call
+	// Seconds allowed for the full-table reconciliation scans instead of
+	// QueryTimeout, 0 for no limit
+	ReconcileTimeout int
+
+	// Scheme for newly written password hashes: bcrypt (default), argon2id or md5
+	PasswordHash string
+}
+
+// AdminConfig represents the admin HTTP listener configuration
+type AdminConfig struct {
+	IP         string
+	Port       int    // 0 disables the admin listener
+	AllowedIPs string // CIDR list of admin clients, separate from InternalIPMask
+	Token      string // Bearer token for /api/; empty disables the API and leaves /status
+}
+
+// LockoutConfig represents brute-force protection configuration
+type LockoutConfig struct {
+	Window             int // Seconds of failure history considered
+	Cooldown           int // Seconds a tripped account or IP stays blocked
+	MaxAccountFailures int // Failures per account within Window, 0 disables
+	MaxIPFailures      int // Failures per source IP within Window, 0 disables
+}
+
+// ReconcileConfig represents the periodic coin ledger reconciliation
+type ReconcileConfig struct {
+	Interval           int // Seconds between runs, 0 disables the job
+	DoubleChargeWindow int // Seconds within which identical debits are flagged, 0 disables
+}
+
+// RechargeConfig represents web shop top-ups
+type RechargeConfig struct {
+	Secret string // HMAC-SHA256 key shared with the shop; empty refuses all recharges
+}
+
+// PlayTimeConfig represents the daily play-time limit. The keys are those of
+// bishop.ini [Test], so the section can be copied over unchanged.
+type PlayTimeConfig struct {
+	LimitPlayTimeFlag  int // 1 enables the limit; 0 or -1 disables it
+	LimitOnlineSecond  int // Online seconds allowed per account per day
+	LimitOfflineSecond int // Seconds offline after which the count restarts, 0 or -1 only at midnight
+}
+
+// LogConfig represents logging configuration
+type LogConfig struct {
+	Level       string // debug, info, warn or error
+	PacketTrace bool   // Dump raw packets at debug level
 }
 
 // LoadConfig loads configuration from INI file
 func LoadConfig(filename string) (*Config, error) {
-	content, err := readFile(filename)
+	return Load(filename, Overrides{})
+}
+
+// Load reads the INI file, applies overrides on top and validates the result
+func Load(filename string, overrides Overrides) (*Config, error) {
+	content, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	config := &Config{}
-	err = parseINI(content, config)
+	config := &Config{
+		Paysys: PaysysConfig{
+			PingCycle:       10,
+			MaxMissedPings:  3,
+			MaxConnections:  512,
+			MaxPacketSize:   4096,
+			WriteTimeout:    10,
+			ShutdownGrace:   5,
+			ShutdownTimeout: 15,
+			AuthMode:        AuthModeStrict,
+		},
+		Database: DatabaseConfig{Port: 3306, ReconcileTimeout: 300},
+		Log:      LogConfig{Level: "info"},
+		Admin:    AdminConfig{AllowedIPs: "127.0.0.1/32"},
+		Lockout: LockoutConfig{
+			Window:             300,
+			Cooldown:           900,
+			MaxAccountFailures: 5,
+		},
+		Reconcile: ReconcileConfig{DoubleChargeWindow: 10},
+	}
+	seen, err := parseINI(string(content), config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if err := overrides.apply(config, seen); err != nil {
+		return nil, err
+	}
+	if err := config.validate(seen); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", filename, err)
+	}
 
 	return config, nil
 }
 
-func readFile(filename string) (string, error) {
-	// Simple file reading implementation
-	// In production, you'd use ioutil.ReadFile or os.ReadFile
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", err
+// sectionNames maps lower-case section names, including the original
+// binaries' [Mysql] and bishop.ini's [Test], to the sections understood here
+var sectionNames = map[string]string{
+	"paysys":    "Paysys",
+	"database":  "Database",
+	"mysql":     "Database",
+	"admin":     "Admin",
+	"lockout":   "Lockout",
+	"reconcile": "Reconcile",
+	"recharge":  "Recharge",
+	"playtime":  "PlayTime",
+	"test":      "PlayTime",
+	"log":       "Log",
+}
+
+// keyNames maps lower-case keys to canonical key names per section. Aliases
+// cover the names used by paysys-linux (IP, Port), KG_SimulatePaysys_FS and
+// vzopaysys (szPaysysIPAddress, nPaysysPort, [Mysql] Host/Username) and the
+// ListenIP/ListenPort spelling. An empty name marks an original key that is
+// recognised but has no effect here; those are listed in one warning.
+var keyNames = map[string]map[string]string{
+	"Paysys": withAliases(map[string]string{
+		"listenip":                 "IP",
+		"szpaysysipaddress":        "IP",
+		"listenport":               "Port",
+		"npaysysport":              "Port",
+		"nmaxrecvbufsizepersocket": "MaxPacketSize",
+		"nmaxsendbufsizepersocket": "",
+		"nusejxmode":               "",
+		"nmaxaccepteachwait":       "",
+		"nmaxeventcount":           "",
+	}, "IP", "Port", "PingCycle", "MaxMissedPings", "MaxConnections", "RecvBufSize",
+		"SendBufSize", "MaxPacketSize", "WriteTimeout", "ShutdownGrace", "ShutdownTimeout",
+		"InternalIPMask", "LocalIP", "AuthMode", "ProvisionalPackets"),
+	"Database": withAliases(map[string]string{
+		"host": "IP",
+	}, "IP", "Port", "UserName", "Password", "DBName", "MaxOpenConns", "MaxIdleConns",
+		"ConnMaxLifetime", "QueryTimeout", "ReconcileTimeout", "PasswordHash"),
+	"Admin":     withAliases(nil, "IP", "Port", "AllowedIPs", "Token"),
+	"Lockout":   withAliases(nil, "Window", "Cooldown", "MaxAccountFailures", "MaxIPFailures"),
+	"Reconcile": withAliases(nil, "Interval", "DoubleChargeWindow"),
+	"Recharge":  withAliases(nil, "Secret"),
+	"PlayTime": withAliases(map[string]string{
+		"testenable": "",
+	}, "LimitPlayTimeFlag", "LimitOnlineSecond", "LimitOfflineSecond"),
+	"Log": withAliases(nil, "Level", "PacketTrace"),
+}
+
+// withAliases adds the canonical names themselves to an alias table
+func withAliases(aliases map[string]string, names ...string) map[string]string {
+	table := make(map[string]string, len(aliases)+len(names))
+	for alias, name := range aliases {
+		table[alias] = name
 	}
-	defer file.Close()
+	for _, name := range names {
+		table[strings.ToLower(name)] = name
+	}
+	return table
+}
+
+// isLegacyResultKey matches the KG_SimulatePaysys_FS canned answers
+// (nUserLoginResult, uAccountState, szPhoneNumber, ...). Paysys answers from
+// the account store instead, so they are reported as unsupported and skipped.
+func isLegacyResultKey(key string) bool {
+	lower := strings.ToLower(key)
+	switch lower {
+	case "uaccountstate", "szphonenumber", "nextpoint", "nzonechargeflag", "nchargeflag", "npasspodtype":
+		return true
+	}
+	return strings.HasPrefix(lower, "n") && strings.HasSuffix(lower, "result")
+}
 
-	content := make([]byte, 0, 1024)
-	buffer := make([]byte, 512)
-	for {
-		n, err := file.Read(buffer)
-		if n > 0 {
-			content = append(content, buffer[:n]...)
+// parseValue strips an inline comment and surrounding quotes. A comment
+// starts at ';' or '#' preceded by whitespace outside quotes; a quoted value
+// keeps those characters and its inner spaces.
+func parseValue(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 0 && (raw[0] == '"' || raw[0] == '\'') {
+		if end := strings.IndexByte(raw[1:], raw[0]); end >= 0 {
+			return raw[1 : end+1]
 		}
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			return "", err
+	}
+	for i := 1; i < len(raw); i++ {
+		if (raw[i] == ';' || raw[i] == '#') && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+			return strings.TrimSpace(raw[:i])
 		}
 	}
-	return string(content), nil
+	return raw
 }
 
-func parseINI(content string, config *Config) error {
+// parseINI applies every recognised key to config and returns the
+// "Section.Key" names that were set. Unknown sections and keys are logged
+// and skipped.
+func parseINI(content string, config *Config) (map[string]bool, error) {
 	lines := strings.Split(content, "\n")
-	var currentSection string
+	var currentSection, rawSection string
+	var simulated, ignored []string
+	seen := make(map[string]bool)
 
-	for _, line := range lines {
+	for i, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
 			continue
 		}
 
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			currentSection = strings.Trim(line, "[]")
+		if strings.HasPrefix(line, "[") {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated section header: %s", i+1, line)
+			}
+			rawSection = strings.TrimSpace(line[1:end])
+			currentSection = sectionNames[strings.ToLower(rawSection)]
+			if currentSection == "" {
+				logger.Warn("unknown config section, ignoring its keys", "section", rawSection, "line", i+1)
+			}
 			continue
 		}
 
-		if strings.Contains(line, "=") {
-			parts := strings.SplitN(line, "=", 2)
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			logger.Warn("ignoring config line without '='", "line", i+1)
+			continue
+		}
+		if currentSection == "" {
+			if rawSection == "" {
+				logger.Warn("config key outside any section, ignoring", "key", strings.TrimSpace(parts[0]), "line", i+1)
+			}
+			continue
+		}
 
-			err := setConfigValue(config, currentSection, key, value)
-			if err != nil {
-				return err
+		rawKey := strings.TrimSpace(parts[0])
+		key, known := keyNames[currentSection][strings.ToLower(rawKey)]
+		if !known {
+			if currentSection == "Paysys" && isLegacyResultKey(rawKey) {
+				simulated = append(simulated, rawKey)
+				continue
 			}
+			logger.Warn("unknown config key, ignoring", "section", rawSection, "key", rawKey, "line", i+1)
+			continue
 		}
+		if key == "" {
+			ignored = append(ignored, rawKey)
+			continue
+		}
+
+		if err := setConfigValue(config, currentSection, key, parseValue(parts[1])); err != nil {
+			return nil, fmt.Errorf("line %d: [%s] %s: %w", i+1, rawSection, rawKey, err)
+		}
+		seen[currentSection+"."+key] = true
 	}
 
-	return nil
+	if len(simulated) > 0 {
+		logger.Warn("simulated result overrides are not supported, ignoring", "keys", simulated)
+	}
+	if len(ignored) > 0 {
+		logger.Warn("original keys have no effect here, ignoring", "keys", ignored)
+	}
+	return seen, nil
 }
 
 func setConfigValue(config *Config, section, key, value string) error {
@@ -121,10 +338,71 @@ func setConfigValue(config *Config, section, key, value string) error {
 				return fmt.Errorf("invalid ping cycle value: %s", value)
 			}
 			config.Paysys.PingCycle = cycle
+		case "MaxMissedPings":
+			missed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid max missed pings value: %s", value)
+			}
+			config.Paysys.MaxMissedPings = missed
+		case "MaxConnections":
+			conns, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid max connections value: %s", value)
+			}
+			config.Paysys.MaxConnections = conns
+		case "RecvBufSize":
+			size, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid receive buffer size value: %s", value)
+			}
+			config.Paysys.RecvBufSize = size
+		case "SendBufSize":
+			size, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid send buffer size value: %s", value)
+			}
+			config.Paysys.SendBufSize = size
+		case "MaxPacketSize":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 4 || size > 65535 {
+				return fmt.Errorf("invalid max packet size value: %s", value)
+			}
+			config.Paysys.MaxPacketSize = size
+		case "WriteTimeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid write timeout value: %s", value)
+			}
+			config.Paysys.WriteTimeout = timeout
+		case "ShutdownGrace":
+			grace, err := strconv.Atoi(value)
+			if err != nil || grace < 0 {
+				return fmt.Errorf("invalid shutdown grace value: %s", value)
+			}
+			config.Paysys.ShutdownGrace = grace
+		case "ShutdownTimeout":
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid shutdown timeout value: %s", value)
+			}
+			config.Paysys.ShutdownTimeout = timeout
 		case "InternalIPMask":
 			config.Paysys.InternalIPMask = value
 		case "LocalIP":
 			config.Paysys.LocalIP = value
+		case "ProvisionalPackets":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid provisional packets value: %s", value)
+			}
+			config.Paysys.ProvisionalPackets = enabled
+		case "AuthMode":
+			switch value {
+			case AuthModeStrict, AuthModeDevAcceptAll, AuthModeStoreFallback:
+				config.Paysys.AuthMode = value
+			default:
+				return fmt.Errorf("invalid auth mode value: %s", value)
+			}
 		}
 	case "Database":
 		switch key {
@@ -142,7 +420,109 @@ func setConfigValue(config *Config, section, key, value string) error {
 			config.Database.Password = value
 		case "DBName":
 			config.Database.DBName = value
+		case "MaxOpenConns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid max open conns value: %s", value)
+			}
+			config.Database.MaxOpenConns = n
+		case "MaxIdleConns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid max idle conns value: %s", value)
+			}
+			config.Database.MaxIdleConns = n
+		case "ConnMaxLifetime":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid conn max lifetime value: %s", value)
+			}
+			config.Database.ConnMaxLifetime = n
+		case "QueryTimeout":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid query timeout value: %s", value)
+			}
+			config.Database.QueryTimeout = n
+		case "ReconcileTimeout":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid reconcile timeout value: %s", value)
+			}
+			config.Database.ReconcileTimeout = n
+		case "PasswordHash":
+			config.Database.PasswordHash = value
+		}
+	case "Admin":
+		switch key {
+		case "IP":
+			config.Admin.IP = value
+		case "Port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid admin port value: %s", value)
+			}
+			config.Admin.Port = port
+		case "AllowedIPs":
+			config.Admin.AllowedIPs = value
+		case "Token":
+			config.Admin.Token = value
+		}
+	case "Lockout":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid lockout %s value: %s", key, value)
+		}
+		switch key {
+		case "Window":
+			config.Lockout.Window = n
+		case "Cooldown":
+			config.Lockout.Cooldown = n
+		case "MaxAccountFailures":
+			config.Lockout.MaxAccountFailures = n
+		case "MaxIPFailures":
+			config.Lockout.MaxIPFailures = n
+		}
+	case "Reconcile":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid reconcile %s value: %s", key, value)
+		}
+		switch key {
+		case "Interval":
+			config.Reconcile.Interval = n
+		case "DoubleChargeWindow":
+			config.Reconcile.DoubleChargeWindow = n
+		}
+	case "PlayTime":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid play time %s value: %s", key, value)
+		}
+		switch key {
+		case "LimitPlayTimeFlag":
+			config.PlayTime.LimitPlayTimeFlag = n
+		case "LimitOnlineSecond":
+			config.PlayTime.LimitOnlineSecond = n
+		case "LimitOfflineSecond":
+			config.PlayTime.LimitOfflineSecond = n
+		}
+	case "Recharge":
+		switch key {
+		case "Secret":
+			config.Recharge.Secret = value
+		}
+	case "Log":
+		switch key {
+		case "Level":
+			config.Log.Level = value
+		case "PacketTrace":
+			trace, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid packet trace value: %s", value)
+			}
+			config.Log.PacketTrace = trace
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
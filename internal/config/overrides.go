@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Overrides are settings from the environment or the command line, applied
+// over the INI file before validation. Empty fields leave the file's value.
+type Overrides struct {
+	Listen         string // host:port for [Paysys] IP and Port
+	DBDSN          string // user:password@tcp(host:port)/dbname
+	DBHost         string
+	DBPort         string
+	DBUser         string
+	DBPassword     string
+	DBName         string
+	LogLevel       string
+	AuthMode       string
+	AdminToken     string
+	RechargeSecret string
+}
+
+// Environment variables read by EnvOverrides
+const (
+	EnvConfig         = "PAYSYS_CONFIG"
+	EnvListen         = "PAYSYS_LISTEN"
+	EnvDBDSN          = "PAYSYS_DB_DSN"
+	EnvDBHost         = "PAYSYS_DB_HOST"
+	EnvDBPort         = "PAYSYS_DB_PORT"
+	EnvDBUser         = "PAYSYS_DB_USER"
+	EnvDBPassword     = "PAYSYS_DB_PASSWORD"
+	EnvDBName         = "PAYSYS_DB_NAME"
+	EnvLogLevel       = "PAYSYS_LOG_LEVEL"
+	EnvAuthMode       = "PAYSYS_AUTH_MODE"
+	EnvAdminToken     = "PAYSYS_ADMIN_TOKEN"
+	EnvRechargeSecret = "PAYSYS_RECHARGE_SECRET"
+)
+
+// EnvOverrides reads the PAYSYS_* environment variables
+func EnvOverrides() Overrides {
+	return Overrides{
+		Listen:         os.Getenv(EnvListen),
+		DBDSN:          os.Getenv(EnvDBDSN),
+		DBHost:         os.Getenv(EnvDBHost),
+		DBPort:         os.Getenv(EnvDBPort),
+		DBUser:         os.Getenv(EnvDBUser),
+		DBPassword:     os.Getenv(EnvDBPassword),
+		DBName:         os.Getenv(EnvDBName),
+		LogLevel:       os.Getenv(EnvLogLevel),
+		AuthMode:       os.Getenv(EnvAuthMode),
+		AdminToken:     os.Getenv(EnvAdminToken),
+		RechargeSecret: os.Getenv(EnvRechargeSecret),
+	}
+}
+
+// apply writes the non-empty overrides into c and marks them as set. A DSN
+// is applied first so the individual database fields can refine it.
+func (o Overrides) apply(c *Config, seen map[string]bool) error {
+	set := func(section, key, value string) error {
+		if value == "" {
+			return nil
+		}
+		if err := setConfigValue(c, section, key, value); err != nil {
+			return fmt.Errorf("override %s.%s: %w", section, key, err)
+		}
+		seen[section+"."+key] = true
+		return nil
+	}
+
+	if o.Listen != "" {
+		host, port, err := net.SplitHostPort(o.Listen)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", o.Listen, err)
+		}
+		c.Paysys.IP = host
+		seen["Paysys.IP"] = true
+		if err := set("Paysys", "Port", port); err != nil {
+			return err
+		}
+	}
+
+	if o.DBDSN != "" {
+		dsn, err := mysql.ParseDSN(o.DBDSN)
+		if err != nil {
+			return fmt.Errorf("invalid database DSN: %w", err)
+		}
+		host, port, err := net.SplitHostPort(dsn.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid database DSN address %q: %w", dsn.Addr, err)
+		}
+		c.Database.Password = dsn.Passwd
+		seen["Database.Password"] = true
+		for _, kv := range [][2]string{{"IP", host}, {"Port", port}, {"UserName", dsn.User}, {"DBName", dsn.DBName}} {
+			if err := set("Database", kv[0], kv[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, kv := range [][3]string{
+		{"Database", "IP", o.DBHost},
+		{"Database", "Port", o.DBPort},
+		{"Database", "UserName", o.DBUser},
+		{"Database", "Password", o.DBPassword},
+		{"Database", "DBName", o.DBName},
+		{"Log", "Level", o.LogLevel},
+		{"Paysys", "AuthMode", o.AuthMode},
+		{"Admin", "Token", o.AdminToken},
+		{"Recharge", "Secret", o.RechargeSecret},
+	} {
+		if err := set(kv[0], kv[1], kv[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// MinAdminTokenLength keeps guessable admin tokens out of the config
+const MinAdminTokenLength = 16
+
+// MinRechargeSecretLength does the same for the shop's signing key
+const MinRechargeSecretLength = 16
+
+// validate checks required settings and value ranges. seen holds the
+// "Section.Key" names present in the file. Every problem is reported, not
+// just the first.
+func (c *Config) validate(seen map[string]bool) error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	require := func(key string) {
+		if !seen[key] {
+			fail("missing required setting %s", key)
+		}
+	}
+	inRange := func(key string, value, min, max int) {
+		if value < min || value > max {
+			fail("%s must be between %d and %d, got %d", key, min, max, value)
+		}
+	}
+
+	require("Paysys.Port")
+	inRange("Paysys.Port", c.Paysys.Port, 1, 65535)
+	inRange("Paysys.PingCycle", c.Paysys.PingCycle, 1, 3600)
+	inRange("Paysys.MaxMissedPings", c.Paysys.MaxMissedPings, 1, 100)
+	inRange("Paysys.MaxConnections", c.Paysys.MaxConnections, 0, 1<<20)
+	inRange("Paysys.RecvBufSize", c.Paysys.RecvBufSize, 0, 1<<24)
+	inRange("Paysys.SendBufSize", c.Paysys.SendBufSize, 0, 1<<24)
+
+	// The database is never opened in dev-accept-all mode
+	if c.Paysys.AuthMode != AuthModeDevAcceptAll {
+		require("Database.IP")
+		require("Database.UserName")
+		require("Database.DBName")
+		if seen["Database.DBName"] && c.Database.DBName == "" {
+			fail("Database.DBName must not be empty")
+		}
+	}
+	inRange("Database.Port", c.Database.Port, 1, 65535)
+	inRange("Database.MaxOpenConns", c.Database.MaxOpenConns, 0, 10000)
+	inRange("Database.MaxIdleConns", c.Database.MaxIdleConns, 0, 10000)
+	inRange("Database.ConnMaxLifetime", c.Database.ConnMaxLifetime, 0, 86400)
+	inRange("Database.QueryTimeout", c.Database.QueryTimeout, 0, 3600)
+	inRange("Database.ReconcileTimeout", c.Database.ReconcileTimeout, 0, 86400)
+	switch c.Database.PasswordHash {
+	case "", "bcrypt", "argon2id", "md5":
+	default:
+		fail("Database.PasswordHash must be bcrypt, argon2id or md5, got %q", c.Database.PasswordHash)
+	}
+
+	inRange("Admin.Port", c.Admin.Port, 0, 65535)
+	if c.Admin.Port != 0 && c.Admin.Port == c.Paysys.Port && c.Admin.IP == c.Paysys.IP {
+		fail("Admin.Port %d clashes with Paysys.Port", c.Admin.Port)
+	}
+	if c.Admin.Port != 0 && strings.TrimSpace(c.Admin.AllowedIPs) == "" {
+		fail("Admin.AllowedIPs must not be empty while the admin listener is enabled")
+	}
+	if c.Admin.Token != "" && len(c.Admin.Token) < MinAdminTokenLength {
+		fail("Admin.Token must be at least %d characters", MinAdminTokenLength)
+	}
+
+	inRange("Lockout.Window", c.Lockout.Window, 0, 86400)
+	inRange("Lockout.Cooldown", c.Lockout.Cooldown, 0, 7*86400)
+	inRange("Lockout.MaxAccountFailures", c.Lockout.MaxAccountFailures, 0, 1000)
+	inRange("Lockout.MaxIPFailures", c.Lockout.MaxIPFailures, 0, 100000)
+	if (c.Lockout.MaxAccountFailures > 0 || c.Lockout.MaxIPFailures > 0) && c.Lockout.Window == 0 {
+		fail("Lockout.Window must be set when a failure threshold is enabled")
+	}
+
+	inRange("Reconcile.Interval", c.Reconcile.Interval, 0, 7*86400)
+	inRange("Reconcile.DoubleChargeWindow", c.Reconcile.DoubleChargeWindow, 0, 86400)
+
+	inRange("PlayTime.LimitPlayTimeFlag", c.PlayTime.LimitPlayTimeFlag, -1, 1)
+	inRange("PlayTime.LimitOnlineSecond", c.PlayTime.LimitOnlineSecond, -1, 86400)
+	inRange("PlayTime.LimitOfflineSecond", c.PlayTime.LimitOfflineSecond, -1, 7*86400)
+	if c.PlayTime.LimitPlayTimeFlag > 0 && c.PlayTime.LimitOnlineSecond <= 0 {
+		fail("PlayTime.LimitOnlineSecond must be set when LimitPlayTimeFlag is 1")
+	}
+
+	if c.Recharge.Secret != "" && len(c.Recharge.Secret) < MinRechargeSecretLength {
+		fail("Recharge.Secret must be at least %d characters", MinRechargeSecretLength)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("Log.Level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadString writes content to a temporary paysys.ini and loads it
+func loadString(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "paysys.ini")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path, Overrides{})
+}
+
+const minimalINI = `[Paysys]
+Port=8000
+AuthMode=dev-accept-all
+`
+
+func TestAdminAllowedIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   string
+		wantErr bool
+	}{
+		{"default list", "[Admin]\nPort=8001\n", false},
+		{"explicit list", "[Admin]\nPort=8001\nAllowedIPs=10.0.0.0/8\n", false},
+		{"empty list with listener", "[Admin]\nPort=8001\nAllowedIPs=\n", true},
+		{"empty list without listener", "[Admin]\nPort=0\nAllowedIPs=\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadString(t, minimalINI+tt.admin)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "Admin.AllowedIPs") {
+				t.Errorf("error %q does not name Admin.AllowedIPs", err)
+			}
+		})
+	}
+}
+
+func TestOriginalBufferKeys(t *testing.T) {
+	cfg, err := loadString(t, minimalINI+"nMaxRecvBufSizePerSocket=2048\nnMaxSendBufSizePerSocket=2048\n")
+	if err != nil {
+		t.Fatalf("Load error = %v", err)
+	}
+	if cfg.Paysys.MaxPacketSize != 2048 {
+		t.Errorf("MaxPacketSize = %d, want 2048", cfg.Paysys.MaxPacketSize)
+	}
+	if cfg.Paysys.RecvBufSize != 0 || cfg.Paysys.SendBufSize != 0 {
+		t.Errorf("socket buffers = %d/%d, want the kernel defaults (0/0)", cfg.Paysys.RecvBufSize, cfg.Paysys.SendBufSize)
+	}
+}
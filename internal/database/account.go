@@ -0,0 +1,300 @@
+package database
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Account administration used by the GM command line and the admin API. Every
+// mutation runs in one transaction with its account_audit rows.
+
+var (
+	// ErrAccountExists is returned when creating a username that is taken
+	ErrAccountExists = errors.New("account already exists")
+	// ErrInsufficientCoin is returned when a debit would make coin negative
+	ErrInsufficientCoin = errors.New("insufficient coin")
+)
+
+// MaxUsernameLength is the longest username the game packets can carry; the
+// 32-byte field needs a terminating NUL
+const MaxUsernameLength = 31
+
+// mysqlDuplicateEntry is ER_DUP_ENTRY
+const mysqlDuplicateEntry = 1062
+
+// extPointSlots are the nExtpoin columns in the account table. There is no
+// nExtpoin3 in the original schema.
+var extPointSlots = []int{1, 2, 4, 5, 6, 7}
+
+// ExtPointSlots returns the valid extpoint slot numbers in order
+func ExtPointSlots() []int {
+	return append([]int(nil), extPointSlots...)
+}
+
+// extPointColumn returns the column for an extpoint slot
+func extPointColumn(slot int) (string, error) {
+	for _, s := range extPointSlots {
+		if s == slot {
+			return "nExtpoin" + strconv.Itoa(slot), nil
+		}
+	}
+	return "", fmt.Errorf("invalid extpoint slot %d, must be one of 1, 2, 4, 5, 6, 7", slot)
+}
+
+// extPointColumnList is the extpoint columns in slot order for a SELECT
+func extPointColumnList() string {
+	cols := make([]string, len(extPointSlots))
+	for i, slot := range extPointSlots {
+		cols[i] = "nExtpoin" + strconv.Itoa(slot)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// PasswordDigest returns the uppercase MD5 hex of a plaintext password, the
+// form the client sends at login
+func PasswordDigest(plain string) string {
+	sum := md5.Sum([]byte(plain))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ValidateUsername checks that username fits the account table and the
+// game packets
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errors.New("username must not be empty")
+	}
+	if len(username) > MaxUsernameLength {
+		return fmt.Errorf("username must be at most %d characters", MaxUsernameLength)
+	}
+	for _, r := range username {
+		if r <= ' ' || r > '~' {
+			return fmt.Errorf("username must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// NewAccount holds the values for CreateAccount
+type NewAccount struct {
+	Username    string
+	Password    string // Plaintext
+	SecPassword string // Plaintext; empty uses Password
+	Email       string // Empty keeps the schema default
+}
+
+// CreateAccount inserts an active, unlocked account. The password is stored
+// with the configured hash scheme; secpassword is stored as lowercase MD5 hex
+// like the web tools do.
+func (c *Connection) CreateAccount(ctx context.Context, actor string, acc NewAccount) error {
+	if err := ValidateUsername(acc.Username); err != nil {
+		return err
+	}
+	if acc.Password == "" {
+		return errors.New("password must not be empty")
+	}
+	secPassword := acc.SecPassword
+	if secPassword == "" {
+		secPassword = acc.Password
+	}
+
+	hash, err := c.verifier.Hash(PasswordDigest(acc.Password))
+	if err != nil {
+		return err
+	}
+
+	return c.inAuditTx(ctx, "create account", actor, func(ctx context.Context, tx *auditTx) error {
+		cols := "username, password, secpassword, dateCreate"
+		vals := "?, ?, ?, UNIX_TIMESTAMP()"
+		args := []any{acc.Username, hash, strings.ToLower(PasswordDigest(secPassword))}
+		if acc.Email != "" {
+			cols += ", email"
+			vals += ", ?"
+			args = append(args, acc.Email)
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO account ("+cols+") VALUES ("+vals+")", args...)
+		if err != nil {
+			var me *mysql.MySQLError
+			if errors.As(err, &me) && me.Number == mysqlDuplicateEntry {
+				return ErrAccountExists
+			}
+			return fmt.Errorf("failed to create account: %w", err)
+		}
+		return tx.audit(ctx, acc.Username, "create", "", "", "")
+	})
+}
+
+// SetLocked locks or unlocks an account. Unlocking also clears a brute-force
+// hack lock so the account can log in straight away.
+func (c *Connection) SetLocked(ctx context.Context, actor, username string, locked bool) error {
+	action, value := "unlock", 0
+	if locked {
+		action, value = "lock", 1
+	}
+	return c.inAuditTx(ctx, "set account lock", actor, func(ctx context.Context, tx *auditTx) error {
+		return setLockedTx(ctx, tx, username, action, value, !locked)
+	})
+}
+
+// setLockedTx sets account.locked and, with clearHackLock, the trytohack,
+// newlocked and lockedTime flags written by SetHackLock, auditing each field
+// that changes
+func setLockedTx(ctx context.Context, tx *auditTx, username, action string, locked int, clearHackLock bool) error {
+	var oldLocked, oldTryToHack, oldNewLocked int
+	var oldLockedTime sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT locked, trytohack, newlocked, lockedTime FROM account WHERE username = ? FOR UPDATE",
+		username).Scan(&oldLocked, &oldTryToHack, &oldNewLocked, &oldLockedTime)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read account: %w", err)
+	}
+
+	type change struct{ field, old, new string }
+	changes := []change{{"locked", strconv.Itoa(oldLocked), strconv.Itoa(locked)}}
+	query := "UPDATE account SET locked = ? WHERE username = ?"
+	if clearHackLock && oldTryToHack != 0 {
+		query = "UPDATE account SET locked = ?, trytohack = 0, newlocked = 0, lockedTime = NULL WHERE username = ?"
+		changes = append(changes,
+			change{"trytohack", strconv.Itoa(oldTryToHack), "0"},
+			change{"newlocked", strconv.Itoa(oldNewLocked), "0"},
+			change{"lockedTime", formatAuditTime(oldLockedTime), ""})
+	}
+	if _, err := tx.ExecContext(ctx, query, locked, username); err != nil {
+		return fmt.Errorf("failed to update account state: %w", err)
+	}
+
+	for _, ch := range changes {
+		if err := tx.audit(ctx, username, action, ch.field, ch.old, ch.new); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatAuditTime renders a nullable datetime for account_audit
+func formatAuditTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.DateTime)
+}
+
+// SetPassword replaces an account's password without checking the old one
+func (c *Connection) SetPassword(ctx context.Context, actor, username, password string) error {
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+	hash, err := c.verifier.Hash(PasswordDigest(password))
+	if err != nil {
+		return err
+	}
+
+	return c.inAuditTx(ctx, "set password", actor, func(ctx context.Context, tx *auditTx) error {
+		var id int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM account WHERE username = ? FOR UPDATE", username).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read account: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE account SET password = ? WHERE id = ?", hash, id); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		return tx.audit(ctx, username, "set-password", "password", auditRedacted, auditRedacted)
+	})
+}
+
+// AddCoin adds amount (negative to debit) to an account's coin as a GM
+// adjustment and returns the new balance. The balance never goes below zero.
+func (c *Connection) AddCoin(ctx context.Context, actor, username string, amount int64) (int64, error) {
+	return c.ApplyCoin(ctx, actor, CoinChange{Username: username, Amount: amount, Kind: LedgerAdjust})
+}
+
+// SetExtPoint sets one of the nExtpoin columns
+func (c *Connection) SetExtPoint(ctx context.Context, actor, username string, slot, value int) error {
+	column, err := extPointColumn(slot)
+	if err != nil {
+		return err
+	}
+
+	return c.inAuditTx(ctx, "set extpoint", actor, func(ctx context.Context, tx *auditTx) error {
+		var old int
+		err := tx.QueryRowContext(ctx, "SELECT "+column+" FROM account WHERE username = ? FOR UPDATE", username).Scan(&old)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", column, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE account SET "+column+" = ? WHERE username = ?", value, username); err != nil {
+			return fmt.Errorf("failed to update %s: %w", column, err)
+		}
+		return tx.audit(ctx, username, "set-extpoint", column, strconv.Itoa(old), strconv.Itoa(value))
+	})
+}
+
+// AccountFilter selects accounts for ListAccounts
+type AccountFilter struct {
+	Prefix     string // Username prefix, empty for all
+	LockedOnly bool
+	Limit      int // <= 0 for no limit
+}
+
+// ListAccounts returns accounts ordered by username. Only the identity,
+// state and balance fields of AccountInfo are filled in.
+func (c *Connection) ListAccounts(ctx context.Context, filter AccountFilter) ([]AccountInfo, error) {
+	defer observeQuery("list_accounts", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT id, username, active, locked, newlocked, trytohack, coin, testcoin FROM account WHERE 1 = 1"
+	var args []any
+	if filter.Prefix != "" {
+		// Escape LIKE wildcards so the prefix matches literally
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(filter.Prefix)
+		query += " AND username LIKE ?"
+		args = append(args, escaped+"%")
+	}
+	if filter.LockedOnly {
+		query += " AND locked <> 0"
+	}
+	query += " ORDER BY username"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []AccountInfo
+	for rows.Next() {
+		var a AccountInfo
+		if err := rows.Scan(&a.ID, &a.Username, &a.Active, &a.Locked, &a.NewLocked, &a.TryToHack, &a.Coin, &a.TestCoin); err != nil {
+			return nil, fmt.Errorf("failed to read account: %w", err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	return accounts, nil
+}
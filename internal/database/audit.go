@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Actors for changes made by paysys itself. People and clients are recorded
+// as "cli:<os user>", "api:<address>", "shop:<address>" or
+// "gateway:<session id>".
+const (
+	ActorLockout = "system:lockout" // Brute-force protection
+	ActorLogin   = "system:login"   // Password hash upgrade on login
+)
+
+// GatewayActor names a Bishop session as the actor of a change
+func GatewayActor(sessionID string) string {
+	return "gateway:" + sessionID
+}
+
+// AuditRecord is one row of account_audit: a single field changed on an
+// account. Rows written by the same transaction share TxnID.
+type AuditRecord struct {
+	ID       int64     `json:"id"`
+	TxnID    string    `json:"txn_id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"` // Who made the change, e.g. "cli:alice"
+	Username string    `json:"username"`
+	Action   string    `json:"action"` // create, lock, unlock, set-password, add-coin, ...
+	Field    string    `json:"field"`  // Column changed, empty for create
+	OldValue string    `json:"old_value"`
+	NewValue string    `json:"new_value"`
+}
+
+// auditRedacted stands in for secret values in account_audit
+const auditRedacted = "<redacted>"
+
+// auditTx is a transaction whose audit rows share one transaction id and
+// actor, so every row commits or rolls back with the change it describes
+type auditTx struct {
+	*sql.Tx
+	id    string
+	actor string
+}
+
+// audit inserts one account_audit row
+func (t *auditTx) audit(ctx context.Context, username, action, field, oldValue, newValue string) error {
+	_, err := t.ExecContext(ctx,
+		"INSERT INTO account_audit (txn_id, actor, username, action, field, old_value, new_value) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.id, t.actor, username, action, field, oldValue, newValue)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+// inAuditTx runs fn in a transaction bounded by the query timeout and commits
+// it if fn succeeds
+func (c *Connection) inAuditTx(ctx context.Context, what, actor string, fn func(context.Context, *auditTx) error) error {
+	defer observeQuery(what, time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	id, err := newTxnID()
+	if err != nil {
+		return err
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin %s transaction: %w", what, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(ctx, &auditTx{Tx: tx, id: id, actor: actor}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", what, err)
+	}
+	return nil
+}
+
+// newTxnID returns a random 32-character hex transaction id
+func newTxnID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate transaction id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AccountAudit returns the most recent audit records for username, newest
+// first. limit <= 0 returns all of them.
+func (c *Connection) AccountAudit(ctx context.Context, username string, limit int) ([]AuditRecord, error) {
+	defer observeQuery("account_audit", time.Now())
+
+	query := auditColumns + " WHERE username = ? ORDER BY id DESC"
+	args := []any{username}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return c.queryAudit(ctx, query, args...)
+}
+
+// CoinHistory returns every recorded change to username's coin, oldest
+// first. Replaying NewValue in order reconstructs the balance over time.
+func (c *Connection) CoinHistory(ctx context.Context, username string) ([]AuditRecord, error) {
+	defer observeQuery("coin_history", time.Now())
+
+	return c.queryAudit(ctx, auditColumns+" WHERE username = ? AND field = 'coin' ORDER BY id", username)
+}
+
+// auditColumns selects AuditRecord fields in Scan order
+const auditColumns = "SELECT id, txn_id, created_at, actor, username, action, field, old_value, new_value FROM account_audit"
+
+func (c *Connection) queryAudit(ctx context.Context, query string, args ...any) ([]AuditRecord, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AuditRecord
+	for rows.Next() {
+		var r AuditRecord
+		if err := rows.Scan(&r.ID, &r.TxnID, &r.Time, &r.Actor, &r.Username, &r.Action, &r.Field, &r.OldValue, &r.NewValue); err != nil {
+			return nil, fmt.Errorf("failed to read audit record: %w", err)
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit records: %w", err)
+	}
+	return records, nil
+}
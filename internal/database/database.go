@@ -1,13 +1,21 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/logging"
 )
 
+var logger = logging.For("database")
+
 // AccountInfo represents the full account structure from jx2_paysys.sql
 type AccountInfo struct {
 	ID          int    `json:"id"`
@@ -22,15 +30,100 @@ type AccountInfo struct {
 	Coin        int64  `json:"coin"`
 	TestCoin    int    `json:"testcoin"`
 	Email       string `json:"email"`
+
+	LastLoginIP int64       `json:"last_login_ip"`
+	LockedTime  *time.Time  `json:"locked_time,omitempty"` // Timed lock expiry, nil when unset
+	LockedCoin  int         `json:"locked_coin"`
+	ExtPoints   map[int]int `json:"extpoints"`   // nExtpoin<slot> by slot number
+	DateCreate  int64       `json:"date_create"` // Unix time, 0 when unset
+}
+
+// LoginResult is the outcome of a single login verification
+type LoginResult int
+
+const (
+	LoginOK            LoginResult = iota // Credentials valid and account usable
+	LoginNotFound                         // No account with that username
+	LoginWrongPassword                    // Password hash does not match
+	LoginInactive                         // active = 0
+	LoginLocked                           // locked != 0
+	LoginNewLocked                        // newlocked != 0
+	LoginTimedLocked                      // lockedTime is still in the future
+	LoginTooManyAttempts                  // Brute-force lockout (trytohack) in cool-down
+)
+
+// String returns a short name for log output
+func (r LoginResult) String() string {
+	switch r {
+	case LoginOK:
+		return "ok"
+	case LoginNotFound:
+		return "not found"
+	case LoginWrongPassword:
+		return "wrong password"
+	case LoginInactive:
+		return "inactive"
+	case LoginLocked:
+		return "locked"
+	case LoginNewLocked:
+		return "newlocked"
+	case LoginTimedLocked:
+		return "timed-locked"
+	case LoginTooManyAttempts:
+		return "too many attempts"
+	default:
+		return fmt.Sprintf("LoginResult(%d)", int(r))
+	}
+}
+
+// Hot-path queries prepared once at connection time
+const (
+	queryVerifyLogin     = "SELECT password, active, locked, newlocked, trytohack, (lockedTime IS NOT NULL AND lockedTime > NOW()) FROM account WHERE username = ?"
+	queryGetAccountState = "SELECT locked FROM account WHERE username = ?"
+	queryGetCoinBalance  = "SELECT coin FROM account WHERE username = ?"
+)
+
+// ErrUnavailable is returned by store methods while MySQL cannot be reached
+var ErrUnavailable = errors.New("database unavailable")
+
+// ErrAccountNotFound is returned when no account has the given username
+var ErrAccountNotFound = errors.New("account not found")
+
+// statements holds the prepared hot-path queries
+type statements struct {
+	verifyLogin     *sql.Stmt
+	getAccountState *sql.Stmt
+	getCoinBalance  *sql.Stmt
+}
+
+// close releases every prepared statement
+func (s *statements) close() {
+	for _, stmt := range []*sql.Stmt{s.verifyLogin, s.getAccountState, s.getCoinBalance} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
 }
 
 // Connection wraps the database connection
 type Connection struct {
-	db *sql.DB
+	db               *sql.DB
+	queryTimeout     time.Duration
+	reconcileTimeout time.Duration
+	verifier         PasswordVerifier
+
+	stmts   atomic.Pointer[statements]
+	healthy atomic.Bool
 }
 
-// NewConnection creates a new database connection
-func NewConnection(cfg config.DatabaseConfig) (*Connection, error) {
+// Open creates the connection pool without requiring MySQL to be reachable.
+// Call Connect (or run Monitor) before using the store.
+func Open(cfg config.DatabaseConfig) (*Connection, error) {
+	verifier, err := NewPasswordVerifier(cfg.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		cfg.UserName, cfg.Password, cfg.IP, cfg.Port, cfg.DBName)
 
@@ -39,39 +132,333 @@ func NewConnection(cfg config.DatabaseConfig) (*Connection, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	// Apply pool tuning from [Database]; zero values keep the driver defaults
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+	}
+
+	return &Connection{
+		db:               db,
+		queryTimeout:     time.Duration(cfg.QueryTimeout) * time.Second,
+		reconcileTimeout: time.Duration(cfg.ReconcileTimeout) * time.Second,
+		verifier:         verifier,
+	}, nil
+}
+
+// NewConnection creates a new database connection
+func NewConnection(cfg config.DatabaseConfig) (*Connection, error) {
+	c, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Connect(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Connect pings MySQL, prepares the hot-path statements on first success and
+// marks the connection healthy
+func (c *Connection) Connect(ctx context.Context) error {
+	defer observeQuery("connect", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	// Test the connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if err := c.db.PingContext(ctx); err != nil {
+		c.setHealthy(false)
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	if c.stmts.Load() == nil {
+		stmts, err := c.prepareStatements(ctx)
+		if err != nil {
+			c.setHealthy(false)
+			return err
+		}
+		if !c.stmts.CompareAndSwap(nil, stmts) {
+			stmts.close()
+		}
 	}
 
-	return &Connection{db: db}, nil
+	c.setHealthy(true)
+	return nil
 }
 
-// Close closes the database connection
+// prepareStatements prepares the queries used on every login
+func (c *Connection) prepareStatements(ctx context.Context) (*statements, error) {
+	var err error
+	s := &statements{}
+	if s.verifyLogin, err = c.db.PrepareContext(ctx, queryVerifyLogin); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to prepare verify login: %w", err)
+	}
+	if s.getAccountState, err = c.db.PrepareContext(ctx, queryGetAccountState); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to prepare account state: %w", err)
+	}
+	if s.getCoinBalance, err = c.db.PrepareContext(ctx, queryGetCoinBalance); err != nil {
+		s.close()
+		return nil, fmt.Errorf("failed to prepare coin balance: %w", err)
+	}
+	return s, nil
+}
+
+// prepared returns the hot-path statements, or ErrUnavailable before the
+// first successful Connect
+func (c *Connection) prepared() (*statements, error) {
+	s := c.stmts.Load()
+	if s == nil {
+		return nil, ErrUnavailable
+	}
+	return s, nil
+}
+
+// withTimeout derives a context bounded by the configured query timeout
+func (c *Connection) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.queryTimeout)
+}
+
+// withReconcileTimeout derives a context bounded by the reconcile timeout,
+// for scans over whole tables that take longer than a normal query
+func (c *Connection) withReconcileTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.reconcileTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.reconcileTimeout)
+}
+
+// Close closes the prepared statements and the database connection
 func (c *Connection) Close() error {
+	if s := c.stmts.Swap(nil); s != nil {
+		s.close()
+	}
+	c.healthy.Store(false)
 	return c.db.Close()
 }
 
-// AccountLogin verifies account credentials using the real JX2 schema
-func (c *Connection) AccountLogin(username, password string) (bool, error) {
-	var count int
-	// Use the real table name 'account' (singular) and check both active status and not locked
-	query := "SELECT COUNT(*) FROM account WHERE username = ? AND password = ? AND active = 1 AND locked = 0"
-	err := c.db.QueryRow(query, username, password).Scan(&count)
+// AccountLogin verifies account credentials using the real JX2 schema and
+// reports whether the account may log in
+func (c *Connection) AccountLogin(ctx context.Context, username, password string) (bool, error) {
+	result, err := c.VerifyLogin(ctx, username, password)
 	if err != nil {
-		return false, fmt.Errorf("failed to query account: %w", err)
+		return false, err
 	}
-	return count > 0, nil
+	return result == LoginOK, nil
+}
+
+// VerifyLogin checks credentials and account state in a single round trip.
+// The password is only compared once the row is found, and the account flags
+// are only reported once the password matches.
+func (c *Connection) VerifyLogin(ctx context.Context, username, password string) (LoginResult, error) {
+	defer observeQuery("verify_login", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stmts, err := c.prepared()
+	if err != nil {
+		return LoginNotFound, err
+	}
+
+	var (
+		stored                               string
+		active, locked, newLocked, tryToHack int
+		timedLocked                          bool
+	)
+	err = stmts.verifyLogin.QueryRowContext(ctx, username).Scan(&stored, &active, &locked, &newLocked, &tryToHack, &timedLocked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return LoginNotFound, nil
+		}
+		return LoginNotFound, fmt.Errorf("failed to verify login: %w", err)
+	}
+
+	// A brute-force lockout (see SetHackLock) rejects before the password is
+	// checked so the attacker learns nothing; once lockedTime has passed the
+	// flags are ignored until the sweeper clears them
+	if tryToHack != 0 && newLocked != 0 {
+		if timedLocked {
+			return LoginTooManyAttempts, nil
+		}
+		newLocked = 0
+	}
+
+	ok, needsUpgrade, err := c.verifier.Verify(stored, password)
+	if err != nil {
+		return LoginNotFound, fmt.Errorf("failed to verify password for %s: %w", username, err)
+	}
+	if !ok {
+		return LoginWrongPassword, nil
+	}
+
+	switch {
+	case active == 0:
+		return LoginInactive, nil
+	case locked != 0:
+		return LoginLocked, nil
+	case newLocked != 0:
+		return LoginNewLocked, nil
+	case timedLocked:
+		return LoginTimedLocked, nil
+	}
+
+	if needsUpgrade {
+		c.upgradePassword(ctx, username, stored, password)
+	}
+	return LoginOK, nil
+}
+
+// upgradePassword rewrites a legacy stored hash after a successful login.
+// Failures are only logged; the login itself has already succeeded.
+func (c *Connection) upgradePassword(ctx context.Context, username, stored, password string) {
+	hash, err := c.verifier.Hash(password)
+	if err != nil {
+		logger.Warn("failed to hash password upgrade", "username", username, "err", err)
+		return
+	}
+
+	upgraded := false
+	err = c.inAuditTx(ctx, "upgrade password", ActorLogin, func(ctx context.Context, tx *auditTx) error {
+		// Only replace the value we verified against, in case it changed meanwhile
+		res, err := tx.ExecContext(ctx, "UPDATE account SET password = ? WHERE username = ? AND password = ?", hash, username, stored)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		upgraded = true
+		return tx.audit(ctx, username, "rehash", "password", auditRedacted, auditRedacted)
+	})
+	if err != nil {
+		logger.Warn("failed to upgrade password hash", "username", username, "err", err)
+		return
+	}
+	if upgraded {
+		logger.Info("upgraded password hash", "username", username)
+	}
+}
+
+// SetHackLock marks an account as locked by brute-force protection until the
+// given time: trytohack and newlocked are set and lockedTime holds the expiry.
+// Unknown usernames are ignored.
+func (c *Connection) SetHackLock(ctx context.Context, username string, until time.Time) error {
+	return c.inAuditTx(ctx, "set hack lock", ActorLockout, func(ctx context.Context, tx *auditTx) error {
+		var oldTryToHack, oldNewLocked int
+		var oldLockedTime sql.NullTime
+		err := tx.QueryRowContext(ctx,
+			"SELECT trytohack, newlocked, lockedTime FROM account WHERE username = ? FOR UPDATE",
+			username).Scan(&oldTryToHack, &oldNewLocked, &oldLockedTime)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read account: %w", err)
+		}
+
+		query := "UPDATE account SET trytohack = 1, newlocked = 1, lockedTime = ? WHERE username = ?"
+		if _, err := tx.ExecContext(ctx, query, until, username); err != nil {
+			return fmt.Errorf("failed to set hack lock: %w", err)
+		}
+		for _, ch := range [][3]string{
+			{"trytohack", strconv.Itoa(oldTryToHack), "1"},
+			{"newlocked", strconv.Itoa(oldNewLocked), "1"},
+			{"lockedTime", formatAuditTime(oldLockedTime), until.Format(time.DateTime)},
+		} {
+			if err := tx.audit(ctx, username, "hack-lock", ch[0], ch[1], ch[2]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClearExpiredHackLocks releases brute-force lockouts whose lockedTime has
+// passed and returns how many accounts were released
+func (c *Connection) ClearExpiredHackLocks(ctx context.Context) (int64, error) {
+	var cleared int64
+	err := c.inAuditTx(ctx, "clear hack locks", ActorLockout, func(ctx context.Context, tx *auditTx) error {
+		const expired = "trytohack = 1 AND newlocked = 1 AND lockedTime IS NOT NULL AND lockedTime <= NOW()"
+		rows, err := tx.QueryContext(ctx, "SELECT username, lockedTime FROM account WHERE "+expired+" FOR UPDATE")
+		if err != nil {
+			return fmt.Errorf("failed to find expired hack locks: %w", err)
+		}
+		type expiredLock struct {
+			username   string
+			lockedTime sql.NullTime
+		}
+		var locks []expiredLock
+		for rows.Next() {
+			var l expiredLock
+			if err := rows.Scan(&l.username, &l.lockedTime); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read expired hack lock: %w", err)
+			}
+			locks = append(locks, l)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to find expired hack locks: %w", err)
+		}
+		if len(locks) == 0 {
+			return nil
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE account SET trytohack = 0, newlocked = 0, lockedTime = NULL WHERE "+expired)
+		if err != nil {
+			return fmt.Errorf("failed to clear hack locks: %w", err)
+		}
+		cleared, _ = res.RowsAffected()
+		for _, l := range locks {
+			for _, ch := range [][3]string{
+				{"trytohack", "1", "0"},
+				{"newlocked", "1", "0"},
+				{"lockedTime", formatAuditTime(l.lockedTime), ""},
+			} {
+				if err := tx.audit(ctx, l.username, "hack-unlock", ch[0], ch[1], ch[2]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return cleared, nil
 }
 
 // GetAccountState gets the account state (using locked field from real schema)
-func (c *Connection) GetAccountState(username string) (int, error) {
+func (c *Connection) GetAccountState(ctx context.Context, username string) (int, error) {
+	defer observeQuery("get_account_state", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stmts, err := c.prepared()
+	if err != nil {
+		return 0, err
+	}
+
 	var locked int
-	query := "SELECT locked FROM account WHERE username = ?"
-	err := c.db.QueryRow(query, username).Scan(&locked)
+	err = stmts.getAccountState.QueryRowContext(ctx, username).Scan(&locked)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, fmt.Errorf("account not found")
+			return 0, ErrAccountNotFound
 		}
 		return 0, fmt.Errorf("failed to get account state: %w", err)
 	}
@@ -79,38 +466,62 @@ func (c *Connection) GetAccountState(username string) (int, error) {
 }
 
 // UpdateAccountState updates the account locked state
-func (c *Connection) UpdateAccountState(username string, locked int) error {
-	query := "UPDATE account SET locked = ? WHERE username = ?"
-	_, err := c.db.Exec(query, locked, username)
-	if err != nil {
-		return fmt.Errorf("failed to update account state: %w", err)
-	}
-	return nil
+func (c *Connection) UpdateAccountState(ctx context.Context, actor, username string, locked int) error {
+	return c.inAuditTx(ctx, "update account state", actor, func(ctx context.Context, tx *auditTx) error {
+		return setLockedTx(ctx, tx, username, "set-state", locked, false)
+	})
 }
 
 // GetAccountInfo gets comprehensive account information
-func (c *Connection) GetAccountInfo(username string) (*AccountInfo, error) {
+func (c *Connection) GetAccountInfo(ctx context.Context, username string) (*AccountInfo, error) {
+	defer observeQuery("get_account_info", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	var acc AccountInfo
+	var lockedTime sql.NullTime
+	var dateCreate sql.NullInt64
+	ext := make([]int, len(extPointSlots))
 	query := `SELECT id, username, password, secpassword, active, locked, newlocked, 
-			         trytohack, trytocard, coin, testcoin, email 
+			         trytohack, trytocard, coin, testcoin, email,
+			         LastLoginIP, lockedTime, lockedCoin, dateCreate, ` + extPointColumnList() + `
 			  FROM account WHERE username = ?`
-	err := c.db.QueryRow(query, username).Scan(
+	dest := []any{
 		&acc.ID, &acc.Username, &acc.Password, &acc.SecPassword,
 		&acc.Active, &acc.Locked, &acc.NewLocked, &acc.TryToHack,
-		&acc.TryToCard, &acc.Coin, &acc.TestCoin, &acc.Email)
+		&acc.TryToCard, &acc.Coin, &acc.TestCoin, &acc.Email,
+		&acc.LastLoginIP, &lockedTime, &acc.LockedCoin, &dateCreate}
+	for i := range ext {
+		dest = append(dest, &ext[i])
+	}
+	err := c.db.QueryRowContext(ctx, query, username).Scan(dest...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("account not found")
+			return nil, ErrAccountNotFound
 		}
 		return nil, fmt.Errorf("failed to get account info: %w", err)
 	}
+	if lockedTime.Valid {
+		acc.LockedTime = &lockedTime.Time
+	}
+	acc.DateCreate = dateCreate.Int64
+	acc.ExtPoints = make(map[int]int, len(ext))
+	for i, slot := range extPointSlots {
+		acc.ExtPoints[slot] = ext[i]
+	}
 	return &acc, nil
 }
 
 // UpdateLastLoginIP updates the last login IP for an account
-func (c *Connection) UpdateLastLoginIP(username string, ip uint32) error {
+func (c *Connection) UpdateLastLoginIP(ctx context.Context, username string, ip uint32) error {
+	defer observeQuery("update_last_login_ip", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	query := "UPDATE account SET LastLoginIP = ? WHERE username = ?"
-	_, err := c.db.Exec(query, ip, username)
+	_, err := c.db.ExecContext(ctx, query, ip, username)
 	if err != nil {
 		return fmt.Errorf("failed to update last login IP: %w", err)
 	}
@@ -118,57 +529,97 @@ func (c *Connection) UpdateLastLoginIP(username string, ip uint32) error {
 }
 
 // GetCoinBalance gets the coin balance for an account
-func (c *Connection) GetCoinBalance(username string) (int64, error) {
+func (c *Connection) GetCoinBalance(ctx context.Context, username string) (int64, error) {
+	defer observeQuery("get_coin_balance", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stmts, err := c.prepared()
+	if err != nil {
+		return 0, err
+	}
+
 	var coin int64
-	query := "SELECT coin FROM account WHERE username = ?"
-	err := c.db.QueryRow(query, username).Scan(&coin)
+	err = stmts.getCoinBalance.QueryRowContext(ctx, username).Scan(&coin)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, fmt.Errorf("account not found")
+			return 0, ErrAccountNotFound
 		}
 		return 0, fmt.Errorf("failed to get coin balance: %w", err)
 	}
 	return coin, nil
 }
 
-// UpdateCoinBalance updates the coin balance for an account
-func (c *Connection) UpdateCoinBalance(username string, amount int64, updateType uint8) error {
-	var query string
-	switch updateType {
-	case 0: // Set
-		query = "UPDATE account SET coin = ? WHERE username = ?"
-	case 1: // Add
-		query = "UPDATE account SET coin = coin + ? WHERE username = ?"
-	case 2: // Subtract
-		query = "UPDATE account SET coin = coin - ? WHERE username = ?"
-	default:
+// UpdateCoinBalance updates the coin balance for an account. updateType 0
+// sets the balance, 1 adds and 2 subtracts amount; the balance never goes
+// below zero.
+func (c *Connection) UpdateCoinBalance(ctx context.Context, actor, username string, amount int64, updateType uint8) error {
+	if updateType > 2 {
 		return fmt.Errorf("invalid update type: %d", updateType)
 	}
-	
-	_, err := c.db.Exec(query, amount, username)
+
+	var delta int64
+	err := c.inAuditTx(ctx, "update coin balance", actor, func(ctx context.Context, tx *auditTx) error {
+		var old int64
+		err := tx.QueryRowContext(ctx, "SELECT coin FROM account WHERE username = ? FOR UPDATE", username).Scan(&old)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read coin balance: %w", err)
+		}
+
+		balance := amount
+		switch updateType {
+		case 1: // Add
+			balance = old + amount
+		case 2: // Subtract
+			balance = old - amount
+		}
+		if balance < 0 {
+			return fmt.Errorf("%w: balance %d, new balance would be %d", ErrInsufficientCoin, old, balance)
+		}
+
+		delta = balance - old
+		if err := tx.ledger(ctx, username, LedgerGame, "", delta, balance); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE account SET coin = ? WHERE username = ?", balance, username); err != nil {
+			return fmt.Errorf("failed to update coin balance: %w", err)
+		}
+		return tx.audit(ctx, username, "update-coin", "coin", strconv.FormatInt(old, 10), strconv.FormatInt(balance, 10))
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update coin balance: %w", err)
+		return err
 	}
+	countCoin(delta)
 	return nil
 }
 
-// ChangePassword updates the password for an account
-func (c *Connection) ChangePassword(username, oldPassword, newPassword string) error {
+// ChangePassword updates the password for an account after checking the old
+// one. Both are the client's MD5 hex.
+func (c *Connection) ChangePassword(ctx context.Context, actor, username, oldPassword, newPassword string) error {
 	// First verify the old password
-	isValid, err := c.AccountLogin(username, oldPassword)
+	isValid, err := c.AccountLogin(ctx, username, oldPassword)
 	if err != nil {
 		return fmt.Errorf("failed to verify old password: %w", err)
 	}
 	if !isValid {
 		return fmt.Errorf("invalid old password")
 	}
-	
-	// Update to new password
-	query := "UPDATE account SET password = ? WHERE username = ?"
-	_, err = c.db.Exec(query, newPassword, username)
+
+	hash, err := c.verifier.Hash(newPassword)
 	if err != nil {
-		return fmt.Errorf("failed to update password: %w", err)
+		return err
 	}
-	return nil
+
+	return c.inAuditTx(ctx, "change password", actor, func(ctx context.Context, tx *auditTx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE account SET password = ? WHERE username = ?", hash, username)
+		if err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		return tx.audit(ctx, username, "change-password", "password", auditRedacted, auditRedacted)
+	})
 }
 
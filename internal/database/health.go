@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Reconnect backoff bounds used by Monitor while MySQL is down
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// Healthy reports whether the last ping or reconnect attempt succeeded
+func (c *Connection) Healthy() bool {
+	return c.healthy.Load()
+}
+
+// setHealthy records the health state and logs transitions
+func (c *Connection) setHealthy(healthy bool) {
+	if c.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		logger.Info("connection healthy")
+	} else {
+		logger.Error("connection unhealthy, logins will fail until it recovers")
+	}
+}
+
+// Monitor pings MySQL every interval, the replacement for the original
+// binary's mysql_ping cycle. While the database is down it retries with
+// exponential backoff; database/sql discards the broken connections and dials
+// fresh ones on each attempt. Monitor returns when ctx is cancelled.
+func (c *Connection) Monitor(ctx context.Context, interval time.Duration) {
+	backoff := minReconnectBackoff
+	for {
+		wasHealthy := c.Healthy()
+		wait := interval
+		if !wasHealthy {
+			wait = backoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		err := c.Connect(ctx)
+		switch {
+		case err == nil:
+			backoff = minReconnectBackoff
+		case ctx.Err() != nil:
+			return
+		default:
+			if !wasHealthy {
+				backoff = min(backoff*2, maxReconnectBackoff)
+			}
+			logger.Warn("health check failed", "retry_in", backoff, "err", err)
+		}
+	}
+}
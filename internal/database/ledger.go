@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Coin ledger: every change to account.coin also appends a coin_ledger row in
+// the same transaction, so the sum of an account's deltas is the balance it
+// should have (see migrations/005_coin_ledger.sql).
+
+// Ledger entry kinds
+const (
+	LedgerOpening   = "opening"   // Balance carried over when the ledger was created
+	LedgerAdjust    = "adjust"    // GM credit or debit from the CLI or admin API
+	LedgerGame      = "game"      // Balance change requested by a gateway
+	LedgerRecharge  = "recharge"  // Shop top-up, Reference is the order id
+	LedgerReconcile = "reconcile" // Drift accepted into the ledger by a repair
+)
+
+// ErrDuplicateReference is returned when a ledger entry's reference has
+// already been applied for its kind
+var ErrDuplicateReference = errors.New("reference already applied")
+
+// CoinChange is one credit or debit applied through the ledger
+type CoinChange struct {
+	Username  string
+	Amount    int64  // Negative to debit
+	Kind      string // Ledger* constant
+	Reference string // Optional external id, unique per Kind
+}
+
+// ApplyCoin adds ch.Amount to an account's coin, records it in the ledger
+// and returns the new balance. The balance never goes below zero. A change
+// whose Reference was already applied fails with ErrDuplicateReference and
+// leaves the balance untouched.
+func (c *Connection) ApplyCoin(ctx context.Context, actor string, ch CoinChange) (int64, error) {
+	var balance int64
+	err := c.inAuditTx(ctx, "apply coin", actor, func(ctx context.Context, tx *auditTx) error {
+		var old int64
+		err := tx.QueryRowContext(ctx, "SELECT coin FROM account WHERE username = ? FOR UPDATE", ch.Username).Scan(&old)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read coin balance: %w", err)
+		}
+		balance = old + ch.Amount
+		if balance < 0 {
+			return fmt.Errorf("%w: balance %d, debit %d", ErrInsufficientCoin, old, -ch.Amount)
+		}
+
+		if err := tx.ledger(ctx, ch.Username, ch.Kind, ch.Reference, ch.Amount, balance); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE account SET coin = ? WHERE username = ?", balance, ch.Username); err != nil {
+			return fmt.Errorf("failed to update coin balance: %w", err)
+		}
+		return tx.audit(ctx, ch.Username, coinAuditAction(ch.Kind), "coin", strconv.FormatInt(old, 10), strconv.FormatInt(balance, 10))
+	})
+	if err != nil {
+		return 0, err
+	}
+	countCoin(ch.Amount)
+	return balance, nil
+}
+
+// coinAuditAction is the account_audit action for a ledger kind. GM
+// adjustments keep the add-coin name they had before the ledger.
+func coinAuditAction(kind string) string {
+	if kind == LedgerAdjust {
+		return "add-coin"
+	}
+	return kind
+}
+
+// ledger appends one coin_ledger row. An empty reference is stored as NULL,
+// which the unique key does not compare.
+func (t *auditTx) ledger(ctx context.Context, username, kind, reference string, delta, balance int64) error {
+	ref := sql.NullString{String: reference, Valid: reference != ""}
+	_, err := t.ExecContext(ctx,
+		"INSERT INTO coin_ledger (txn_id, actor, username, kind, reference, delta, balance) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.id, t.actor, username, kind, ref, delta, balance)
+	if err != nil {
+		var me *mysql.MySQLError
+		if errors.As(err, &me) && me.Number == mysqlDuplicateEntry {
+			return fmt.Errorf("%w: %s %s", ErrDuplicateReference, kind, reference)
+		}
+		return fmt.Errorf("failed to write ledger entry: %w", err)
+	}
+	return nil
+}
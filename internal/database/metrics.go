@@ -0,0 +1,46 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"jx2-paysys/internal/metrics"
+)
+
+var (
+	queryDuration = metrics.NewHistogramVec("paysys_db_query_duration_seconds",
+		"Latency of store calls, including transactions, by operation.", "op", metrics.DefaultLatencyBuckets, queryOps...)
+	coinCreditedTotal = metrics.NewCounter("paysys_coin_credited_total",
+		"Coin added to accounts.")
+	coinDebitedTotal = metrics.NewCounter("paysys_coin_debited_total",
+		"Coin taken from accounts.")
+)
+
+// queryOps are the op labels observeQuery is called with, spaces already
+// replaced; any other op is counted as metrics.OtherLabel
+var queryOps = []string{
+	"connect", "verify_login", "get_account_state", "get_account_info",
+	"update_last_login_ip", "get_coin_balance", "list_accounts", "coin_drifts",
+	"double_charges", "record_sessions", "play_sessions", "account_audit",
+	"coin_history",
+	// inAuditTx transactions
+	"apply_coin", "change_password", "clear_hack_locks", "create_account",
+	"repair_coin_drift", "set_account_lock", "set_extpoint", "set_hack_lock",
+	"set_password", "update_account_state", "update_coin_balance", "upgrade_password",
+}
+
+// observeQuery records a store call's latency; use as
+// defer observeQuery("op", time.Now())
+func observeQuery(op string, start time.Time) {
+	queryDuration.ObserveSince(strings.ReplaceAll(op, " ", "_"), start)
+}
+
+// countCoin adds a committed balance change to the credit or debit counter
+func countCoin(delta int64) {
+	switch {
+	case delta > 0:
+		coinCreditedTotal.Add(uint64(delta))
+	case delta < 0:
+		coinDebitedTotal.Add(uint64(-delta))
+	}
+}
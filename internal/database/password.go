@@ -0,0 +1,172 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash schemes selectable with [Database] PasswordHash
+const (
+	PasswordHashMD5      = "md5"      // Legacy: store the client MD5 hex as-is, never upgrade
+	PasswordHashBcrypt   = "bcrypt"   // bcrypt over the client MD5 hex
+	PasswordHashArgon2id = "argon2id" // argon2id over the client MD5 hex, PHC string format
+)
+
+// argon2id parameters for newly written hashes
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024 // KiB
+	argon2Threads = 1
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	// Stored parameters outside these bounds are refused rather than handed
+	// to argon2.IDKey, which panics on zero time, threads or key length. The
+	// upper bounds keep a tampered row from stalling a login or exhausting
+	// memory; they are well above any sane production setting.
+	argon2MaxMemory  = 1 << 18 // KiB, 256 MiB
+	argon2MaxTime    = 10
+	argon2MaxThreads = 16
+)
+
+// PasswordVerifier checks the MD5 hex digest sent by the client against the
+// value stored in account.password. The wire format never changes; only the
+// stored representation does.
+type PasswordVerifier interface {
+	// Verify reports whether md5Hex matches stored, and whether stored should
+	// be rewritten with Hash because it uses a weaker scheme
+	Verify(stored, md5Hex string) (ok bool, needsUpgrade bool, err error)
+	// Hash produces the stored representation for md5Hex
+	Hash(md5Hex string) (string, error)
+}
+
+// NewPasswordVerifier returns the verifier that writes the given scheme and
+// accepts every known scheme when reading
+func NewPasswordVerifier(scheme string) (PasswordVerifier, error) {
+	switch scheme {
+	case "", PasswordHashBcrypt:
+		return &passwordVerifier{scheme: PasswordHashBcrypt}, nil
+	case PasswordHashArgon2id, PasswordHashMD5:
+		return &passwordVerifier{scheme: scheme}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hash scheme: %s", scheme)
+	}
+}
+
+// passwordVerifier is the default PasswordVerifier
+type passwordVerifier struct {
+	scheme string
+}
+
+// Verify implements PasswordVerifier
+func (v *passwordVerifier) Verify(stored, md5Hex string) (bool, bool, error) {
+	// The client sends uppercase hex; normalise so old lowercase rows and
+	// hashes written by other tools compare the same
+	md5Hex = strings.ToUpper(md5Hex)
+
+	switch storedScheme(stored) {
+	case PasswordHashBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(md5Hex))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return true, v.scheme == PasswordHashArgon2id, nil
+	case PasswordHashArgon2id:
+		ok, err := verifyArgon2id(stored, md5Hex)
+		if err != nil {
+			return false, false, err
+		}
+		return ok, false, nil
+	default:
+		// Legacy MD5 hex, stored lowercase by the web tools
+		ok := subtle.ConstantTimeCompare([]byte(strings.ToUpper(stored)), []byte(md5Hex)) == 1
+		return ok, ok && v.scheme != PasswordHashMD5, nil
+	}
+}
+
+// Hash implements PasswordVerifier
+func (v *passwordVerifier) Hash(md5Hex string) (string, error) {
+	md5Hex = strings.ToUpper(md5Hex)
+
+	switch v.scheme {
+	case PasswordHashMD5:
+		return md5Hex, nil
+	case PasswordHashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("failed to generate salt: %w", err)
+		}
+		key := argon2.IDKey([]byte(md5Hex), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		hash, err := bcrypt.GenerateFromPassword([]byte(md5Hex), bcrypt.DefaultCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %w", err)
+		}
+		return string(hash), nil
+	}
+}
+
+// storedScheme identifies the scheme of a stored password value
+func storedScheme(stored string) string {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return PasswordHashBcrypt
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return PasswordHashArgon2id
+	default:
+		return PasswordHashMD5
+	}
+}
+
+// verifyArgon2id checks md5Hex against a PHC-format argon2id string
+func verifyArgon2id(stored, md5Hex string) (bool, error) {
+	// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version: %s", parts[2])
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if time < 1 || time > argon2MaxTime || threads < 1 || threads > argon2MaxThreads ||
+		memory < 8*uint32(threads) || memory > argon2MaxMemory {
+		return false, fmt.Errorf("invalid argon2id parameters: %s", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	if len(key) == 0 {
+		return false, fmt.Errorf("invalid argon2id key: empty")
+	}
+
+	computed := argon2.IDKey([]byte(md5Hex), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
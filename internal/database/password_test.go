@@ -0,0 +1,100 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testMD5   = "E10ADC3949BA59ABBE56E057F20F883E" // MD5 of "123456"
+	otherMD5  = "5F4DCC3B5AA765D61D8327DEB882CF99"
+	testSalt  = "c29tZXNhbHRzb21lc2FsdA" // 16 bytes, RawStdEncoding
+	testKey32 = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+)
+
+func mustVerifier(t *testing.T, scheme string) PasswordVerifier {
+	t.Helper()
+	v, err := NewPasswordVerifier(scheme)
+	if err != nil {
+		t.Fatalf("NewPasswordVerifier(%q): %v", scheme, err)
+	}
+	return v
+}
+
+func mustHash(t *testing.T, scheme string) string {
+	t.Helper()
+	stored, err := mustVerifier(t, scheme).Hash(testMD5)
+	if err != nil {
+		t.Fatalf("Hash with %s: %v", scheme, err)
+	}
+	return stored
+}
+
+func TestVerifyUpgrade(t *testing.T) {
+	bcryptHash := mustHash(t, PasswordHashBcrypt)
+	argonHash := mustHash(t, PasswordHashArgon2id)
+
+	tests := []struct {
+		name        string
+		scheme      string
+		stored      string
+		md5Hex      string
+		wantOK      bool
+		wantUpgrade bool
+	}{
+		{"md5 row upgraded to bcrypt", PasswordHashBcrypt, strings.ToLower(testMD5), testMD5, true, true},
+		{"md5 row kept under md5", PasswordHashMD5, strings.ToLower(testMD5), testMD5, true, false},
+		{"md5 row wrong password", PasswordHashBcrypt, strings.ToLower(testMD5), otherMD5, false, false},
+		{"bcrypt row", PasswordHashBcrypt, bcryptHash, testMD5, true, false},
+		{"bcrypt row lowercase input", PasswordHashBcrypt, bcryptHash, strings.ToLower(testMD5), true, false},
+		{"bcrypt row upgraded to argon2id", PasswordHashArgon2id, bcryptHash, testMD5, true, true},
+		{"bcrypt row wrong password", PasswordHashBcrypt, bcryptHash, otherMD5, false, false},
+		{"argon2id row", PasswordHashBcrypt, argonHash, testMD5, true, false},
+		{"argon2id row wrong password", PasswordHashArgon2id, argonHash, otherMD5, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, upgrade, err := mustVerifier(t, tt.scheme).Verify(tt.stored, tt.md5Hex)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if ok != tt.wantOK || upgrade != tt.wantUpgrade {
+				t.Errorf("Verify = (%v, %v), want (%v, %v)", ok, upgrade, tt.wantOK, tt.wantUpgrade)
+			}
+		})
+	}
+}
+
+func TestVerifyArgon2idMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"too few parts", "$argon2id$v=19$m=19456,t=2,p=1$" + testSalt},
+		{"wrong version", "$argon2id$v=16$m=19456,t=2,p=1$" + testSalt + "$" + testKey32},
+		{"garbled parameters", "$argon2id$v=19$m=x,t=2,p=1$" + testSalt + "$" + testKey32},
+		{"zero time", "$argon2id$v=19$m=19456,t=0,p=1$" + testSalt + "$" + testKey32},
+		{"zero threads", "$argon2id$v=19$m=19456,t=2,p=0$" + testSalt + "$" + testKey32},
+		{"memory below 8 per thread", "$argon2id$v=19$m=15,t=2,p=2$" + testSalt + "$" + testKey32},
+		{"memory too large", "$argon2id$v=19$m=262145,t=2,p=1$" + testSalt + "$" + testKey32},
+		{"time too large", "$argon2id$v=19$m=19456,t=11,p=1$" + testSalt + "$" + testKey32},
+		{"too many threads", "$argon2id$v=19$m=19456,t=2,p=17$" + testSalt + "$" + testKey32},
+		{"empty key", "$argon2id$v=19$m=19456,t=2,p=1$" + testSalt + "$"},
+		{"bad salt", "$argon2id$v=19$m=19456,t=2,p=1$!!!$" + testKey32},
+	}
+	v := mustVerifier(t, PasswordHashBcrypt)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, _, err := v.Verify(tt.stored, testMD5)
+			if err == nil || ok {
+				t.Errorf("Verify = (%v, %v), want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestNewPasswordVerifierUnknown(t *testing.T) {
+	if _, err := NewPasswordVerifier("sha1"); err == nil {
+		t.Error("NewPasswordVerifier(sha1) succeeded, want an error")
+	}
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CoinDrift is an account whose coin differs from the sum of its ledger
+// entries, for example after a manual UPDATE or a lost write
+type CoinDrift struct {
+	Username  string     `json:"username"`
+	Coin      int64      `json:"coin"`     // account.coin
+	Expected  int64      `json:"expected"` // Sum of coin_ledger deltas
+	Drift     int64      `json:"drift"`    // Coin - Expected
+	Entries   int64      `json:"entries"`
+	LastEntry *time.Time `json:"last_entry,omitempty"`
+}
+
+// CoinDrifts returns every account whose coin does not match its ledger,
+// ordered by username
+func (c *Connection) CoinDrifts(ctx context.Context) ([]CoinDrift, error) {
+	defer observeQuery("coin_drifts", time.Now())
+
+	ctx, cancel := c.withReconcileTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT a.username, a.coin, COALESCE(l.total, 0), COALESCE(l.entries, 0), l.last_entry
+		FROM account a
+		LEFT JOIN (SELECT username, SUM(delta) AS total, COUNT(*) AS entries, MAX(created_at) AS last_entry
+			FROM coin_ledger GROUP BY username) l ON l.username = a.username
+		WHERE a.coin <> COALESCE(l.total, 0)
+		ORDER BY a.username`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query coin drift: %w", err)
+	}
+	defer rows.Close()
+
+	var drifts []CoinDrift
+	for rows.Next() {
+		var d CoinDrift
+		var last sql.NullTime
+		if err := rows.Scan(&d.Username, &d.Coin, &d.Expected, &d.Entries, &last); err != nil {
+			return nil, fmt.Errorf("failed to read coin drift: %w", err)
+		}
+		d.Drift = d.Coin - d.Expected
+		if last.Valid {
+			d.LastEntry = &last.Time
+		}
+		drifts = append(drifts, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read coin drift: %w", err)
+	}
+	return drifts, nil
+}
+
+// DoubleCharge is a pair of identical debits to one account close together
+// in time, the usual shape of a charge replayed by a gateway or the shop
+type DoubleCharge struct {
+	Username   string    `json:"username"`
+	Kind       string    `json:"kind"`
+	Delta      int64     `json:"delta"`
+	FirstID    int64     `json:"first_id"`
+	FirstTime  time.Time `json:"first_time"`
+	SecondID   int64     `json:"second_id"`
+	SecondTime time.Time `json:"second_time"`
+}
+
+// SuspectedDoubleCharges returns pairs of debits of the same kind and amount
+// to one account at most window apart, for debits made since since
+func (c *Connection) SuspectedDoubleCharges(ctx context.Context, since time.Time, window time.Duration) ([]DoubleCharge, error) {
+	defer observeQuery("double_charges", time.Now())
+
+	ctx, cancel := c.withReconcileTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT l1.username, l1.kind, l1.delta, l1.id, l1.created_at, l2.id, l2.created_at
+		FROM coin_ledger l1
+		JOIN coin_ledger l2 ON l2.username = l1.username AND l2.id > l1.id
+			AND l2.kind = l1.kind AND l2.delta = l1.delta
+			AND l2.created_at <= l1.created_at + INTERVAL ? SECOND
+		WHERE l1.delta < 0 AND l1.created_at >= ?
+		ORDER BY l1.username, l1.id, l2.id`, int64(window/time.Second), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query double charges: %w", err)
+	}
+	defer rows.Close()
+
+	var charges []DoubleCharge
+	for rows.Next() {
+		var d DoubleCharge
+		if err := rows.Scan(&d.Username, &d.Kind, &d.Delta, &d.FirstID, &d.FirstTime, &d.SecondID, &d.SecondTime); err != nil {
+			return nil, fmt.Errorf("failed to read double charge: %w", err)
+		}
+		charges = append(charges, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read double charges: %w", err)
+	}
+	return charges, nil
+}
+
+// RepairCoinDrift re-checks one account under lock and resolves its drift.
+// By default coin is reset to the ledger's balance; with accept the current
+// coin is kept and the difference is recorded as a reconcile ledger entry,
+// for changes made outside paysys on purpose. It returns the drift found,
+// which is zero if there was nothing to repair.
+func (c *Connection) RepairCoinDrift(ctx context.Context, actor, username string, accept bool) (CoinDrift, error) {
+	d := CoinDrift{Username: username}
+	err := c.inAuditTx(ctx, "repair coin drift", actor, func(ctx context.Context, tx *auditTx) error {
+		err := tx.QueryRowContext(ctx, "SELECT coin FROM account WHERE username = ? FOR UPDATE", username).Scan(&d.Coin)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read coin balance: %w", err)
+		}
+		// Ledger writers lock the account row first, so the sum is stable
+		err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(delta), 0), COUNT(*) FROM coin_ledger WHERE username = ?", username).
+			Scan(&d.Expected, &d.Entries)
+		if err != nil {
+			return fmt.Errorf("failed to sum ledger: %w", err)
+		}
+		d.Drift = d.Coin - d.Expected
+		if d.Drift == 0 {
+			return nil
+		}
+
+		if accept {
+			if err := tx.ledger(ctx, username, LedgerReconcile, "", d.Drift, d.Coin); err != nil {
+				return err
+			}
+			return tx.audit(ctx, username, "reconcile-accept", "coin", strconv.FormatInt(d.Expected, 10), strconv.FormatInt(d.Coin, 10))
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE account SET coin = ? WHERE username = ?", d.Expected, username); err != nil {
+			return fmt.Errorf("failed to update coin balance: %w", err)
+		}
+		return tx.audit(ctx, username, "reconcile", "coin", strconv.FormatInt(d.Coin, 10), strconv.FormatInt(d.Expected, 10))
+	})
+	if err != nil {
+		return CoinDrift{}, err
+	}
+	if !accept {
+		countCoin(-d.Drift)
+	}
+	return d, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SessionRecord is a finished player session
+type SessionRecord struct {
+	Username   string
+	Gateway    string
+	LoginTime  time.Time
+	LogoutTime time.Time
+}
+
+// RecordSessions stores finished sessions in account_session in a single
+// transaction
+func (c *Connection) RecordSessions(ctx context.Context, sessions []SessionRecord) error {
+	defer observeQuery("record_sessions", time.Now())
+
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin session transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO account_session (username, gateway, login_time, logout_time) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare session insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, s := range sessions {
+		if _, err := stmt.ExecContext(ctx, s.Username, s.Gateway, s.LoginTime, s.LogoutTime); err != nil {
+			return fmt.Errorf("failed to record session for %s: %w", s.Username, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit sessions: %w", err)
+	}
+	return nil
+}
+
+// PlaySessions returns the finished sessions of username that ended after
+// since, oldest login first
+func (c *Connection) PlaySessions(ctx context.Context, username string, since time.Time) ([]SessionRecord, error) {
+	defer observeQuery("play_sessions", time.Now())
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx,
+		"SELECT gateway, login_time, logout_time FROM account_session WHERE username = ? AND logout_time > ? ORDER BY login_time",
+		username, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []SessionRecord
+	for rows.Next() {
+		s := SessionRecord{Username: username}
+		if err := rows.Scan(&s.Gateway, &s.LoginTime, &s.LogoutTime); err != nil {
+			return nil, fmt.Errorf("failed to read session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,104 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Filter is an allow-list of source networks. A nil or empty Filter allows
+// every address.
+type Filter struct {
+	networks []*net.IPNet
+}
+
+// Parse builds a Filter from a comma or space separated list. Each entry may
+// be a CIDR ("10.0.0.0/8"), a single address ("192.168.1.20") or an original
+// paysys.ini style mask where trailing zero octets are wildcards
+// ("127.0.0.0" allows 127.x.x.x, "192.168.1.0" allows 192.168.1.x,
+// "0.0.0.0" allows every IPv4 address).
+func Parse(spec string) (*Filter, error) {
+	f := &Filter{}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		network, err := parseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		f.networks = append(f.networks, network)
+	}
+	return f, nil
+}
+
+// parseEntry converts a single list entry to a network
+func parseEntry(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", entry)
+	}
+
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	// Legacy mask form: trailing zero octets are wildcards. The first octet
+	// only widens when all four are zero, the original match-all mask.
+	ones := 32
+	for i := 3; i > 0 && ip4[i] == 0; i-- {
+		ones -= 8
+	}
+	if ip4.Equal(net.IPv4zero) {
+		ones = 0
+	}
+	return &net.IPNet{IP: ip4.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}, nil
+}
+
+// Empty reports whether the filter allows everything
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.networks) == 0
+}
+
+// String lists the allowed networks
+func (f *Filter) String() string {
+	if f.Empty() {
+		return "any"
+	}
+	parts := make([]string, len(f.networks))
+	for i, network := range f.networks {
+		parts[i] = network.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Allow reports whether addr (host or host:port) is inside the allow-list
+func (f *Filter) Allow(addr string) bool {
+	if f.Empty() {
+		return true
+	}
+
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range f.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
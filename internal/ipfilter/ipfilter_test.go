@@ -0,0 +1,92 @@
+package ipfilter
+
+import "testing"
+
+func TestParseAllow(t *testing.T) {
+	tests := []struct {
+		spec  string
+		addr  string
+		allow bool
+	}{
+		// Legacy mask form: trailing zero octets are wildcards
+		{"127.0.0.0", "127.0.0.1", true},
+		{"127.0.0.0", "127.200.3.4:5622", true},
+		{"127.0.0.0", "128.0.0.1", false},
+		{"192.168.1.0", "192.168.1.77", true},
+		{"192.168.1.0", "192.168.2.77", false},
+		{"10.20.0.0", "10.20.99.1", true},
+		{"10.20.0.0", "10.21.0.1", false},
+		// Only trailing zeros widen the mask, and the first octet only in the
+		// all-zero match-all mask
+		{"10.0.5.0", "10.0.5.9", true},
+		{"10.0.5.0", "10.1.5.9", false},
+		{"0.0.0.0", "0.0.0.0", true},
+		{"0.0.0.0", "1.0.0.0", true},
+		{"0.0.0.0", "203.0.113.7:5622", true},
+		{"0.0.0.0", "fd12::1", false},
+		// Single addresses and CIDRs
+		{"192.168.1.20", "192.168.1.20", true},
+		{"192.168.1.20", "192.168.1.21", false},
+		{"10.0.0.0/8", "10.255.0.1:80", true},
+		{"10.0.0.0/8", "11.0.0.1", false},
+		{"::1", "[::1]:8000", true},
+		{"fd00::/8", "fd12::1", true},
+		{"fd00::/8", "fe80::1", false},
+		// Lists
+		{"10.0.0.0/8, 192.168.1.5", "192.168.1.5", true},
+		{"10.0.0.0/8 192.168.1.5", "172.16.0.1", false},
+		// Anything that is not an address is refused
+		{"10.0.0.0/8", "not-an-ip", false},
+		{"10.0.0.0/8", "", false},
+	}
+	for _, tt := range tests {
+		f, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		if got := f.Allow(tt.addr); got != tt.allow {
+			t.Errorf("Parse(%q).Allow(%q) = %v, want %v", tt.spec, tt.addr, got, tt.allow)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "300.1.1.1", "localhost", "10.0.0.0/8,bogus"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	var nilFilter *Filter
+	for _, f := range []*Filter{nilFilter, mustParse(t, ""), mustParse(t, " , ")} {
+		if !f.Empty() {
+			t.Errorf("%v not empty", f)
+		}
+		if !f.Allow("203.0.113.9") {
+			t.Error("empty filter refused an address")
+		}
+		if f.String() != "any" {
+			t.Errorf("String() = %q, want any", f.String())
+		}
+	}
+	if mustParse(t, "127.0.0.0").Empty() {
+		t.Error("non-empty filter reports empty")
+	}
+}
+
+func TestString(t *testing.T) {
+	if got := mustParse(t, "127.0.0.0,192.168.1.20").String(); got != "127.0.0.0/8,192.168.1.20/32" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func mustParse(t *testing.T, spec string) *Filter {
+	t.Helper()
+	f, err := Parse(spec)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", spec, err)
+	}
+	return f
+}
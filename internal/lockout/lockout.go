@@ -0,0 +1,226 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/logging"
+)
+
+var logger = logging.For("lockout")
+
+// Store persists account lockouts so they survive restarts and are visible
+// to the web tools (account.trytohack / newlocked / lockedTime)
+type Store interface {
+	SetHackLock(ctx context.Context, username string, until time.Time) error
+	ClearExpiredHackLocks(ctx context.Context) (int64, error)
+}
+
+// sweepInterval is how often expired counters and stored locks are cleared
+const sweepInterval = time.Minute
+
+// counter tracks failures for one account or source IP
+type counter struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+// record appends a failure, drops those outside the window and reports
+// whether the threshold has been reached
+func (c *counter) record(now time.Time, window time.Duration, max int) bool {
+	c.prune(now, window)
+	c.failures = append(c.failures, now)
+	return max > 0 && len(c.failures) >= max
+}
+
+// prune drops failures older than the sliding window
+func (c *counter) prune(now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(c.failures) && c.failures[i].Before(cutoff) {
+		i++
+	}
+	c.failures = c.failures[i:]
+}
+
+// Tracker counts failed logins per account and per source IP over a sliding
+// window and blocks further attempts for a cool-down once a threshold is hit
+type Tracker struct {
+	cfg   config.LockoutConfig
+	store Store
+
+	mu       sync.Mutex
+	accounts map[string]*counter
+	ips      map[string]*counter
+}
+
+// NewTracker creates a lockout tracker. store may be nil, in which case
+// lockouts are kept in memory only.
+func NewTracker(cfg config.LockoutConfig, store Store) *Tracker {
+	return &Tracker{
+		cfg:      cfg,
+		store:    store,
+		accounts: make(map[string]*counter),
+		ips:      make(map[string]*counter),
+	}
+}
+
+// SetConfig replaces the thresholds. Counters already recorded are kept and
+// judged against the new values from the next failure on.
+func (t *Tracker) SetConfig(cfg config.LockoutConfig) {
+	t.mu.Lock()
+	t.cfg = cfg
+	t.mu.Unlock()
+}
+
+// Enabled reports whether any threshold is configured
+func (t *Tracker) Enabled() bool {
+	if t == nil {
+		return false
+	}
+	cfg := t.config()
+	return cfg.MaxAccountFailures > 0 || cfg.MaxIPFailures > 0
+}
+
+// config returns the current thresholds
+func (t *Tracker) config() config.LockoutConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+// Blocked reports whether the account or IP is currently in cool-down and
+// until when
+func (t *Tracker) Blocked(username, ip string) (bool, time.Time) {
+	if !t.Enabled() {
+		return false, time.Time{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var until time.Time
+	if c, ok := t.accounts[username]; ok && c.lockedUntil.After(now) {
+		until = c.lockedUntil
+	}
+	if c, ok := t.ips[ip]; ok && c.lockedUntil.After(until) {
+		until = c.lockedUntil
+	}
+	return until.After(now), until
+}
+
+// RecordFailure counts a failed login and starts a cool-down when a
+// threshold is crossed. Account lockouts are also written to the store.
+func (t *Tracker) RecordFailure(ctx context.Context, username, ip string) {
+	if !t.Enabled() {
+		return
+	}
+
+	cfg := t.config()
+	now := time.Now()
+	cooldown := time.Duration(cfg.Cooldown) * time.Second
+	window := time.Duration(cfg.Window) * time.Second
+	var lockAccount bool
+
+	t.mu.Lock()
+	if cfg.MaxAccountFailures > 0 {
+		c := t.counterFor(t.accounts, username)
+		if c.record(now, window, cfg.MaxAccountFailures) && !c.lockedUntil.After(now) {
+			c.lockedUntil = now.Add(cooldown)
+			c.failures = nil
+			lockAccount = true
+		}
+	}
+	if cfg.MaxIPFailures > 0 {
+		c := t.counterFor(t.ips, ip)
+		if c.record(now, window, cfg.MaxIPFailures) && !c.lockedUntil.After(now) {
+			c.lockedUntil = now.Add(cooldown)
+			c.failures = nil
+			logger.Warn("source blocked", "ip", ip, "cooldown", cooldown, "failures", cfg.MaxIPFailures)
+		}
+	}
+	t.mu.Unlock()
+
+	if lockAccount {
+		logger.Warn("account locked", "username", username, "cooldown", cooldown, "failures", cfg.MaxAccountFailures)
+		if t.store != nil {
+			if err := t.store.SetHackLock(ctx, username, now.Add(cooldown)); err != nil {
+				logger.Error("failed to persist lock", "username", username, "err", err)
+			}
+		}
+	}
+}
+
+// RecordSuccess clears the failure history of an account after a good login
+func (t *Tracker) RecordSuccess(username string) {
+	if !t.Enabled() {
+		return
+	}
+
+	t.mu.Lock()
+	delete(t.accounts, username)
+	t.mu.Unlock()
+}
+
+// Run periodically drops expired counters and clears stored lockouts whose
+// cool-down has passed. It returns when ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		// Thresholds may be enabled later by a config reload
+		if t.Enabled() {
+			t.sweep(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep removes idle in-memory counters and expired stored lockouts
+func (t *Tracker) sweep(ctx context.Context) {
+	now := time.Now()
+	window := time.Duration(t.config().Window) * time.Second
+
+	t.mu.Lock()
+	for _, m := range []map[string]*counter{t.accounts, t.ips} {
+		for key, c := range m {
+			c.prune(now, window)
+			if len(c.failures) == 0 && !c.lockedUntil.After(now) {
+				delete(m, key)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if t.store != nil {
+		cleared, err := t.store.ClearExpiredHackLocks(ctx)
+		if err != nil {
+			logger.Warn("failed to clear expired locks", "err", err)
+		} else if cleared > 0 {
+			logger.Info("cleared expired account locks", "count", cleared)
+		}
+	}
+}
+
+// counterFor returns the counter for key, creating it if needed
+func (t *Tracker) counterFor(m map[string]*counter, key string) *counter {
+	c, ok := m[key]
+	if !ok {
+		c = &counter{}
+		m[key] = c
+	}
+	return c
+}
@@ -0,0 +1,137 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"jx2-paysys/internal/config"
+)
+
+// fakeStore records the lockouts written through the Store interface
+type fakeStore struct {
+	locks map[string]time.Time
+}
+
+func (s *fakeStore) SetHackLock(ctx context.Context, username string, until time.Time) error {
+	s.locks[username] = until
+	return nil
+}
+
+func (s *fakeStore) ClearExpiredHackLocks(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestCounterWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := 10 * time.Second
+
+	tests := []struct {
+		name    string
+		offsets []time.Duration // Failure times after start
+		max     int
+		want    bool
+	}{
+		{"below threshold", []time.Duration{0, time.Second}, 3, false},
+		{"reaches threshold", []time.Duration{0, time.Second, 2 * time.Second}, 3, true},
+		{"old failures slide out", []time.Duration{0, time.Second, 15 * time.Second}, 3, false},
+		{"threshold zero disables", []time.Duration{0, time.Second, 2 * time.Second}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c counter
+			var got bool
+			for _, offset := range tt.offsets {
+				got = c.record(start.Add(offset), window, tt.max)
+			}
+			if got != tt.want {
+				t.Errorf("record = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerLocksAccount(t *testing.T) {
+	store := &fakeStore{locks: make(map[string]time.Time)}
+	tr := NewTracker(config.LockoutConfig{Window: 60, Cooldown: 300, MaxAccountFailures: 3}, store)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		tr.RecordFailure(ctx, "alice", "10.0.0.1")
+	}
+	if blocked, _ := tr.Blocked("alice", "10.0.0.1"); blocked {
+		t.Fatal("blocked after 2 of 3 failures")
+	}
+
+	tr.RecordFailure(ctx, "alice", "10.0.0.1")
+	blocked, until := tr.Blocked("alice", "10.0.0.2")
+	if !blocked {
+		t.Fatal("not blocked after 3 failures")
+	}
+	if d := time.Until(until); d < 290*time.Second || d > 300*time.Second {
+		t.Errorf("cool-down ends in %v, want about 300s", d)
+	}
+	if _, ok := store.locks["alice"]; !ok {
+		t.Error("lock not written to the store")
+	}
+	if blocked, _ := tr.Blocked("bob", "10.0.0.1"); blocked {
+		t.Error("other account blocked; MaxIPFailures is 0")
+	}
+}
+
+func TestTrackerCooldownExpires(t *testing.T) {
+	tr := NewTracker(config.LockoutConfig{Window: 60, Cooldown: 300, MaxAccountFailures: 1}, nil)
+	tr.RecordFailure(context.Background(), "alice", "10.0.0.1")
+	if blocked, _ := tr.Blocked("alice", "10.0.0.1"); !blocked {
+		t.Fatal("not blocked after reaching the threshold")
+	}
+
+	// Move the cool-down into the past
+	tr.mu.Lock()
+	tr.accounts["alice"].lockedUntil = time.Now().Add(-time.Second)
+	tr.mu.Unlock()
+	if blocked, _ := tr.Blocked("alice", "10.0.0.1"); blocked {
+		t.Error("still blocked after the cool-down")
+	}
+}
+
+func TestTrackerIPThreshold(t *testing.T) {
+	tr := NewTracker(config.LockoutConfig{Window: 60, Cooldown: 300, MaxIPFailures: 2}, nil)
+	ctx := context.Background()
+	tr.RecordFailure(ctx, "alice", "10.0.0.1")
+	tr.RecordFailure(ctx, "bob", "10.0.0.1")
+
+	if blocked, _ := tr.Blocked("carol", "10.0.0.1"); !blocked {
+		t.Error("source not blocked after 2 failures")
+	}
+	if blocked, _ := tr.Blocked("carol", "10.0.0.2"); blocked {
+		t.Error("other source blocked")
+	}
+}
+
+func TestTrackerSuccessClearsHistory(t *testing.T) {
+	tr := NewTracker(config.LockoutConfig{Window: 60, Cooldown: 300, MaxAccountFailures: 2}, nil)
+	ctx := context.Background()
+	tr.RecordFailure(ctx, "alice", "10.0.0.1")
+	tr.RecordSuccess("alice")
+	tr.RecordFailure(ctx, "alice", "10.0.0.1")
+
+	if blocked, _ := tr.Blocked("alice", "10.0.0.1"); blocked {
+		t.Error("blocked although a success cleared the earlier failure")
+	}
+}
+
+func TestTrackerDisabled(t *testing.T) {
+	var nilTracker *Tracker
+	if nilTracker.Enabled() {
+		t.Error("nil tracker reports enabled")
+	}
+
+	tr := NewTracker(config.LockoutConfig{Window: 60, Cooldown: 300}, nil)
+	for i := 0; i < 10; i++ {
+		tr.RecordFailure(context.Background(), "alice", "10.0.0.1")
+	}
+	if blocked, _ := tr.Blocked("alice", "10.0.0.1"); blocked {
+		t.Error("blocked with every threshold at 0")
+	}
+}
@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// redacted replaces sensitive values in log output
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never written
+var sensitiveKeys = map[string]bool{
+	"password":     true,
+	"old_password": true,
+	"new_password": true,
+	"secpassword":  true,
+	"cipher_key":   true,
+	"security_key": true,
+	"token":        true,
+	"secret":       true,
+}
+
+var (
+	level       = new(slog.LevelVar)
+	packetTrace atomic.Bool
+
+	base slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	})
+)
+
+// Secret wraps a value that must never appear in logs, whatever key it is
+// logged under
+type Secret string
+
+// LogValue implements slog.LogValuer
+func (Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// redactAttr blanks attributes with a sensitive key
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+// For returns the logger for a component such as "protocol" or "database"
+func For(component string) *slog.Logger {
+	return slog.New(base).With("component", component)
+}
+
+// SetLevel changes the minimum level for every component logger
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the current minimum level
+func Level() slog.Level {
+	return level.Level()
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level: %s", s)
+	}
+	return l, nil
+}
+
+// SetPacketTrace enables or disables raw packet dumps
+func SetPacketTrace(on bool) {
+	packetTrace.Store(on)
+}
+
+// PacketTrace reports whether raw packet dumps are enabled
+func PacketTrace() bool {
+	return packetTrace.Load()
+}
+
+// TracePacket logs a hex dump of data at debug level, only when packet
+// tracing is enabled in [Log]
+func TracePacket(l *slog.Logger, msg string, data []byte, args ...any) {
+	if !packetTrace.Load() || !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	args = append(args, "bytes", len(data), "data", hex.EncodeToString(data))
+	l.Debug(msg, args...)
+}
@@ -0,0 +1,273 @@
+// Package metrics is a small Prometheus-compatible registry. Packages declare
+// their metrics as package variables on Default; the admin server writes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default is the registry used by the New* functions
+var Default = NewRegistry()
+
+// collector is one metric family
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families by name
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds c, panicking on a duplicate name since that is a programming
+// error
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteText writes every metric in the Prometheus text format, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc is the name and help text shared by every metric type
+type desc struct {
+	fqName string
+	help   string
+	kind   string // counter, gauge or histogram
+}
+
+func (d desc) name() string { return d.fqName }
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, escapeHelp(d.help), d.fqName, d.kind)
+}
+
+// Counter is a monotonically increasing integer
+type Counter struct {
+	desc
+	value atomic.Uint64
+}
+
+// NewCounter registers a counter on Default
+func NewCounter(name, help string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter"}}
+	Default.register(c)
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add adds n
+func (c *Counter) Add(n uint64) { c.value.Add(n) }
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	fmt.Fprintf(w, "%s %d\n", c.fqName, c.value.Load())
+}
+
+// OtherLabel stands for every label value outside a vector's fixed set, so
+// a bug or a misbehaving gateway cannot grow the registry without bound
+const OtherLabel = "other"
+
+// CounterVec is a set of counters split by the value of one label. The label
+// values are fixed when it is registered.
+type CounterVec struct {
+	desc
+	label  string
+	values map[string]*atomic.Uint64 // Never changes after NewCounterVec
+}
+
+// NewCounterVec registers a labelled counter on Default with one series per
+// value plus OtherLabel
+func NewCounterVec(name, help, label string, values ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, "counter"}, label: label, values: make(map[string]*atomic.Uint64)}
+	for _, value := range values {
+		c.values[value] = new(atomic.Uint64)
+	}
+	c.values[OtherLabel] = new(atomic.Uint64)
+	Default.register(c)
+	return c
+}
+
+// Inc adds one to the counter for value
+func (c *CounterVec) Inc(value string) { c.Add(value, 1) }
+
+// Add adds n to the counter for value, or to OtherLabel for a value outside
+// the fixed set
+func (c *CounterVec) Add(value string, n uint64) {
+	v, ok := c.values[value]
+	if !ok {
+		v = c.values[OtherLabel]
+	}
+	v.Add(n)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	for _, value := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.fqName, c.label, escapeLabel(value), c.values[value].Load())
+	}
+}
+
+// funcMetric reads its value from a callback at scrape time, for state
+// other packages already count
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge on Default whose value is fn()
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.register(&funcMetric{desc{name, help, "gauge"}, fn})
+}
+
+// NewCounterFunc registers a counter on Default whose value is fn()
+func NewCounterFunc(name, help string, fn func() float64) {
+	Default.register(&funcMetric{desc{name, help, "counter"}, fn})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", m.fqName, formatFloat(m.fn()))
+}
+
+// DefaultLatencyBuckets suit database round trips, in seconds
+var DefaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// HistogramVec is a set of histograms split by the value of one label. The
+// label values are fixed when it is registered.
+type HistogramVec struct {
+	desc
+	label   string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram holds one label value's observations
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a labelled histogram on Default with one series
+// per value plus OtherLabel
+func NewHistogramVec(name, help, label string, buckets []float64, values ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name, help, "histogram"},
+		label:   label,
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*histogram),
+	}
+	sort.Float64s(h.buckets)
+	for _, value := range values {
+		h.series[value] = &histogram{counts: make([]uint64, len(h.buckets))}
+	}
+	h.series[OtherLabel] = &histogram{counts: make([]uint64, len(h.buckets))}
+	Default.register(h)
+	return h
+}
+
+// Observe records v for value, or for OtherLabel when value is outside the
+// fixed set
+func (h *HistogramVec) Observe(value string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[value]
+	if !ok {
+		s = h.series[OtherLabel]
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveSince records the time elapsed since start, in seconds
+func (h *HistogramVec) ObserveSince(value string, start time.Time) {
+	h.Observe(value, time.Since(start).Seconds())
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, value := range sortedKeys(h.series) {
+		s := h.series[value]
+		label := fmt.Sprintf("%s=\"%s\"", h.label, escapeLabel(value))
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.fqName, label, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.fqName, label, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.fqName, label, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.fqName, label, s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
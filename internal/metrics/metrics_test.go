@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func render(c collector) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	c.write(w)
+	w.Flush()
+	return b.String()
+}
+
+func TestCounterVecFixedLabels(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests.", "result", "ok", "failed")
+	c.Inc("ok")
+	c.Add("failed", 2)
+	for i := 0; i < 100; i++ {
+		c.Inc("unexpected_" + strings.Repeat("x", i))
+	}
+
+	got := render(c)
+	for _, line := range []string{
+		`test_requests_total{result="ok"} 1`,
+		`test_requests_total{result="failed"} 2`,
+		`test_requests_total{result="other"} 100`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("output lacks %q:\n%s", line, got)
+		}
+	}
+	if len(c.values) != 3 {
+		t.Errorf("%d series, want 3", len(c.values))
+	}
+}
+
+func TestHistogramVecFixedLabels(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Durations.", "op", []float64{1}, "read")
+	h.Observe("read", 0.5)
+	h.Observe("write", 2)
+	h.Observe("delete", 0.1)
+
+	got := render(h)
+	for _, line := range []string{
+		`test_duration_seconds_count{op="read"} 1`,
+		`test_duration_seconds_count{op="other"} 2`,
+		`test_duration_seconds_bucket{op="other",le="1"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("output lacks %q:\n%s", line, got)
+		}
+	}
+	if len(h.series) != 2 {
+		t.Errorf("%d series, want 2", len(h.series))
+	}
+}
@@ -0,0 +1,314 @@
+// Package playtime enforces the daily play-time limit configured by the
+// bishop.ini [Test] keys LimitPlayTimeFlag, LimitOnlineSecond and
+// LimitOfflineSecond. Online time is counted per account and calendar day
+// (local time); the count starts again at midnight and once the account has
+// stayed offline for LimitOfflineSecond.
+package playtime
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/logging"
+)
+
+var logger = logging.For("playtime")
+
+// Store reads finished sessions (account_session) so counts survive a
+// restart and logins through other paysys instances
+type Store interface {
+	PlaySessions(ctx context.Context, username string, since time.Time) ([]database.SessionRecord, error)
+}
+
+// account is the play time counted for one account
+type account struct {
+	day        time.Time     // Midnight of the day being counted
+	used       time.Duration // Finished sessions since the last reset
+	online     time.Time     // Start of the counted part of the open session, zero while offline
+	lastLogout time.Time
+	loaded     bool // History read from the store
+}
+
+// roll starts a new count at midnight, and after a long enough break while
+// the account is offline
+func (a *account) roll(now time.Time, offline time.Duration) {
+	day := startOfDay(now)
+	if a.day.Before(day) {
+		a.day, a.used = day, 0
+		if !a.online.IsZero() && a.online.Before(day) {
+			a.online = day
+		}
+	}
+	if a.online.IsZero() && offline > 0 && !a.lastLogout.IsZero() && now.Sub(a.lastLogout) >= offline {
+		a.used = 0
+	}
+}
+
+// total is the time counted so far, including the open session
+func (a *account) total(now time.Time) time.Duration {
+	if a.online.IsZero() {
+		return a.used
+	}
+	return a.used + now.Sub(a.online)
+}
+
+// replay counts stored sessions, oldest login first, that ended after day.
+// The store holds every finished session unless a write failed, so the larger
+// of the stored and the in-memory count wins.
+func (a *account) replay(sessions []database.SessionRecord, day time.Time, offline time.Duration) {
+	var used time.Duration
+	var lastLogout time.Time
+	for _, s := range sessions {
+		login := s.LoginTime
+		if offline > 0 && !lastLogout.IsZero() && login.Sub(lastLogout) >= offline {
+			used = 0
+		}
+		// Overlapping sessions (a login through a second Bishop) count once
+		if login.Before(lastLogout) {
+			login = lastLogout
+		}
+		if login.Before(day) {
+			login = day
+		}
+		if s.LogoutTime.After(login) {
+			used += s.LogoutTime.Sub(login)
+		}
+		if s.LogoutTime.After(lastLogout) {
+			lastLogout = s.LogoutTime
+		}
+	}
+
+	if used > a.used {
+		a.used = used
+	}
+	if lastLogout.After(a.lastLogout) {
+		a.lastLogout = lastLogout
+	}
+	a.loaded = true
+}
+
+// startOfDay returns local midnight of t's day
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Tracker counts online time per account and reports accounts that have
+// used up the day's allowance. Sessions are counted even while the limit is
+// disabled, so enabling it by a config reload applies to players already
+// online.
+type Tracker struct {
+	cfg   config.PlayTimeConfig
+	store Store
+
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+// NewTracker creates a play-time tracker. store may be nil, in which case
+// only sessions seen by this process are counted.
+func NewTracker(cfg config.PlayTimeConfig, store Store) *Tracker {
+	return &Tracker{
+		cfg:      cfg,
+		store:    store,
+		accounts: make(map[string]*account),
+	}
+}
+
+// SetConfig replaces the limits. Time already counted is kept and judged
+// against the new values from the next check on.
+func (t *Tracker) SetConfig(cfg config.PlayTimeConfig) {
+	t.mu.Lock()
+	t.cfg = cfg
+	t.mu.Unlock()
+}
+
+// Enabled reports whether the limit is switched on
+func (t *Tracker) Enabled() bool {
+	if t == nil {
+		return false
+	}
+	cfg := t.config()
+	return cfg.LimitPlayTimeFlag > 0 && cfg.LimitOnlineSecond > 0
+}
+
+// config returns the current limits
+func (t *Tracker) config() config.PlayTimeConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+// limits returns the daily allowance and the offline period that restarts
+// the count (0 when only midnight does)
+func (t *Tracker) limits() (limit, offline time.Duration) {
+	cfg := t.config()
+	limit = time.Duration(cfg.LimitOnlineSecond) * time.Second
+	if cfg.LimitOfflineSecond > 0 {
+		offline = time.Duration(cfg.LimitOfflineSecond) * time.Second
+	}
+	return limit, offline
+}
+
+// Allow reports whether username may log in, and the time counted today.
+// It always allows when the limit is disabled.
+func (t *Tracker) Allow(ctx context.Context, username string) (bool, time.Duration) {
+	if !t.Enabled() {
+		return true, 0
+	}
+	t.load(ctx, username)
+
+	limit, offline := t.limits()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.accountFor(username)
+	a.roll(now, offline)
+	used := a.total(now)
+	return used < limit, used
+}
+
+// Login starts counting a session. A login of an account that is already
+// online keeps counting from the earlier start; callers end the earlier
+// session first when they know it is over.
+func (t *Tracker) Login(username string) {
+	if t == nil {
+		return
+	}
+	_, offline := t.limits()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a := t.accountFor(username)
+	a.roll(now, offline)
+	if a.online.IsZero() {
+		a.online = now
+	}
+}
+
+// Logout stops counting the open session of username
+func (t *Tracker) Logout(username string) {
+	if t == nil {
+		return
+	}
+	_, offline := t.limits()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.accounts[username]
+	if !ok || a.online.IsZero() {
+		return
+	}
+	a.roll(now, offline)
+	a.used = a.total(now)
+	a.online = time.Time{}
+	a.lastLogout = now
+}
+
+// Used returns the time counted today for username
+func (t *Tracker) Used(username string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	_, offline := t.limits()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.accounts[username]
+	if !ok {
+		return 0
+	}
+	a.roll(now, offline)
+	return a.total(now)
+}
+
+// Check drops accounts with nothing left to count and returns the online
+// accounts that have used up today's allowance. It returns nil when the
+// limit is disabled.
+func (t *Tracker) Check(ctx context.Context) []string {
+	if t == nil {
+		return nil
+	}
+	limit, offline := t.limits()
+	enabled := t.Enabled()
+	now := time.Now()
+
+	var unloaded []string
+	t.mu.Lock()
+	for username, a := range t.accounts {
+		a.roll(now, offline)
+		if a.online.IsZero() && a.used == 0 {
+			delete(t.accounts, username)
+			continue
+		}
+		if enabled && !a.loaded && !a.online.IsZero() {
+			unloaded = append(unloaded, username)
+		}
+	}
+	t.mu.Unlock()
+	if !enabled {
+		return nil
+	}
+
+	// Players who logged in while the limit was off have no history yet
+	for _, username := range unloaded {
+		t.load(ctx, username)
+	}
+
+	var exceeded []string
+	t.mu.Lock()
+	for username, a := range t.accounts {
+		if !a.online.IsZero() && a.total(now) >= limit {
+			exceeded = append(exceeded, username)
+		}
+	}
+	t.mu.Unlock()
+	return exceeded
+}
+
+// load reads today's finished sessions of username from the store, once
+func (t *Tracker) load(ctx context.Context, username string) {
+	if t.store == nil {
+		return
+	}
+	t.mu.Lock()
+	a, ok := t.accounts[username]
+	loaded := ok && a.loaded
+	t.mu.Unlock()
+	if loaded {
+		return
+	}
+
+	_, offline := t.limits()
+	now := time.Now()
+	day := startOfDay(now)
+	sessions, err := t.store.PlaySessions(ctx, username, day)
+	if err != nil {
+		logger.Warn("failed to read play history, counting this process's sessions only", "username", username, "err", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a = t.accountFor(username)
+	a.roll(now, offline)
+	a.replay(sessions, day, offline)
+}
+
+// accountFor returns the entry for username, creating it if needed. t.mu
+// must be held.
+func (t *Tracker) accountFor(username string) *account {
+	a, ok := t.accounts[username]
+	if !ok {
+		a = &account{}
+		t.accounts[username] = a
+	}
+	return a
+}
@@ -0,0 +1,191 @@
+package playtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/database"
+)
+
+// fakeStore returns the same finished sessions for every account
+type fakeStore struct {
+	sessions []database.SessionRecord
+	calls    int
+}
+
+func (s *fakeStore) PlaySessions(ctx context.Context, username string, since time.Time) ([]database.SessionRecord, error) {
+	s.calls++
+	return s.sessions, nil
+}
+
+func at(hour, min int) time.Time {
+	return time.Date(2024, 5, 14, hour, min, 0, 0, time.Local)
+}
+
+func TestRoll(t *testing.T) {
+	yesterday := at(22, 0).AddDate(0, 0, -1)
+	tests := []struct {
+		name       string
+		before     account
+		now        time.Time
+		offline    time.Duration
+		wantUsed   time.Duration
+		wantOnline time.Time
+	}{
+		{"same day keeps the count",
+			account{day: at(0, 0), used: time.Hour, lastLogout: at(9, 0)},
+			at(9, 30), 0, time.Hour, time.Time{}},
+		{"midnight resets the count",
+			account{day: at(0, 0).AddDate(0, 0, -1), used: time.Hour, lastLogout: yesterday},
+			at(0, 5), 0, 0, time.Time{}},
+		{"session open over midnight counts from midnight",
+			account{day: at(0, 0).AddDate(0, 0, -1), used: time.Hour, online: yesterday},
+			at(0, 5), 0, 0, at(0, 0)},
+		{"long enough break resets the count",
+			account{day: at(0, 0), used: time.Hour, lastLogout: at(9, 0)},
+			at(9, 10), 10 * time.Minute, 0, time.Time{}},
+		{"short break keeps the count",
+			account{day: at(0, 0), used: time.Hour, lastLogout: at(9, 0)},
+			at(9, 9), 10 * time.Minute, time.Hour, time.Time{}},
+		{"online account is never reset by a break",
+			account{day: at(0, 0), used: time.Hour, online: at(9, 5), lastLogout: at(9, 0)},
+			at(11, 0), 10 * time.Minute, time.Hour, at(9, 5)},
+		{"no offline period resets at midnight only",
+			account{day: at(0, 0), used: time.Hour, lastLogout: at(1, 0)},
+			at(23, 0), 0, time.Hour, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.before
+			a.roll(tt.now, tt.offline)
+			if a.used != tt.wantUsed {
+				t.Errorf("used = %v, want %v", a.used, tt.wantUsed)
+			}
+			if !a.online.Equal(tt.wantOnline) {
+				t.Errorf("online = %v, want %v", a.online, tt.wantOnline)
+			}
+			if !a.day.Equal(at(0, 0)) {
+				t.Errorf("day = %v, want %v", a.day, at(0, 0))
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	session := func(login, logout time.Time) database.SessionRecord {
+		return database.SessionRecord{Username: "alice", LoginTime: login, LogoutTime: logout}
+	}
+	tests := []struct {
+		name           string
+		memory         time.Duration
+		sessions       []database.SessionRecord
+		offline        time.Duration
+		wantUsed       time.Duration
+		wantLastLogout time.Time
+	}{
+		{"sums sessions", 0,
+			[]database.SessionRecord{session(at(8, 0), at(9, 0)), session(at(10, 0), at(10, 30))},
+			0, 90 * time.Minute, at(10, 30)},
+		{"session from yesterday counts from midnight", 0,
+			[]database.SessionRecord{session(at(23, 0).AddDate(0, 0, -1), at(1, 0))},
+			0, time.Hour, at(1, 0)},
+		{"overlapping sessions count once", 0,
+			[]database.SessionRecord{session(at(8, 0), at(9, 0)), session(at(8, 30), at(9, 30))},
+			0, 90 * time.Minute, at(9, 30)},
+		{"contained session adds nothing", 0,
+			[]database.SessionRecord{session(at(8, 0), at(10, 0)), session(at(8, 30), at(9, 0))},
+			0, 2 * time.Hour, at(10, 0)},
+		{"long enough break restarts the count", 0,
+			[]database.SessionRecord{session(at(8, 0), at(9, 0)), session(at(11, 0), at(11, 20))},
+			time.Hour, 20 * time.Minute, at(11, 20)},
+		{"short break keeps the count", 0,
+			[]database.SessionRecord{session(at(8, 0), at(9, 0)), session(at(9, 30), at(9, 50))},
+			time.Hour, 80 * time.Minute, at(9, 50)},
+		{"larger in-memory count wins", 3 * time.Hour,
+			[]database.SessionRecord{session(at(8, 0), at(9, 0))},
+			0, 3 * time.Hour, at(9, 0)},
+		{"no sessions", 0, nil, 0, 0, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := account{day: at(0, 0), used: tt.memory}
+			a.replay(tt.sessions, at(0, 0), tt.offline)
+			if a.used != tt.wantUsed {
+				t.Errorf("used = %v, want %v", a.used, tt.wantUsed)
+			}
+			if !a.lastLogout.Equal(tt.wantLastLogout) {
+				t.Errorf("lastLogout = %v, want %v", a.lastLogout, tt.wantLastLogout)
+			}
+			if !a.loaded {
+				t.Error("not marked loaded")
+			}
+		})
+	}
+}
+
+func TestTrackerAllow(t *testing.T) {
+	now := time.Now()
+	if now.Sub(startOfDay(now)) < 10*time.Minute {
+		t.Skip("too close to midnight for a session earlier today")
+	}
+	store := &fakeStore{sessions: []database.SessionRecord{{
+		Username:   "alice",
+		LoginTime:  now.Add(-5 * time.Minute),
+		LogoutTime: now.Add(-3 * time.Minute),
+	}}}
+	ctx := context.Background()
+
+	tr := NewTracker(config.PlayTimeConfig{LimitPlayTimeFlag: 1, LimitOnlineSecond: 60}, store)
+	if ok, used := tr.Allow(ctx, "alice"); ok || used != 2*time.Minute {
+		t.Errorf("Allow = (%v, %v), want (false, 2m0s)", ok, used)
+	}
+	tr.Allow(ctx, "alice")
+	if store.calls != 1 {
+		t.Errorf("history read %d times, want once", store.calls)
+	}
+
+	tr.SetConfig(config.PlayTimeConfig{LimitPlayTimeFlag: 1, LimitOnlineSecond: 600})
+	if ok, _ := tr.Allow(ctx, "alice"); !ok {
+		t.Error("refused below the raised limit")
+	}
+
+	tr.SetConfig(config.PlayTimeConfig{LimitPlayTimeFlag: 0, LimitOnlineSecond: 60})
+	if ok, _ := tr.Allow(ctx, "alice"); !ok {
+		t.Error("refused with the limit disabled")
+	}
+}
+
+func TestTrackerCheck(t *testing.T) {
+	tr := NewTracker(config.PlayTimeConfig{LimitPlayTimeFlag: 1, LimitOnlineSecond: 60}, nil)
+	tr.Login("alice")
+	tr.Login("bob")
+	tr.Login("carol")
+	tr.Logout("carol")
+
+	// Move alice's session start back past the limit
+	tr.mu.Lock()
+	tr.accounts["alice"].online = time.Now().Add(-2 * time.Minute)
+	tr.mu.Unlock()
+
+	exceeded := tr.Check(context.Background())
+	if len(exceeded) != 1 || exceeded[0] != "alice" {
+		t.Errorf("Check = %v, want [alice]", exceeded)
+	}
+	if used := tr.Used("alice"); used < 2*time.Minute {
+		t.Errorf("Used = %v, want at least 2m", used)
+	}
+
+	tr.Logout("alice")
+	if exceeded := tr.Check(context.Background()); len(exceeded) != 0 {
+		t.Errorf("Check after logout = %v, want none", exceeded)
+	}
+
+	var nilTracker *Tracker
+	if nilTracker.Enabled() || nilTracker.Check(context.Background()) != nil || nilTracker.Used("alice") != 0 {
+		t.Error("nil tracker is not a no-op")
+	}
+	nilTracker.Login("alice")
+	nilTracker.Logout("alice")
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// minFrameSize is the smallest valid frame: the Size and Type header
+const minFrameSize = 4
+
+// DefaultMaxPacketSize is used when no MaxPacketSize is configured
+const DefaultMaxPacketSize = 4096
+
+// readFrame reads one frame using its little-endian Size header. buf bounds
+// the frame size; a header outside [minFrameSize, len(buf)] is rejected
+// before any payload is read, since the stream cannot be resynchronised.
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint16(buf[:2]))
+	if size < minFrameSize || size > len(buf) {
+		return nil, fmt.Errorf("invalid frame size %d (max %d)", size, len(buf))
+	}
+
+	if _, err := io.ReadFull(r, buf[2:size]); err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
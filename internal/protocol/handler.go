@@ -1,109 +1,201 @@
 package protocol
 
 import (
+	"context"
 	"encoding/binary"
-	"log"
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"jx2-paysys/internal/config"
 	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/lockout"
+	"jx2-paysys/internal/logging"
+	"jx2-paysys/internal/playtime"
 )
 
+var logger = logging.For("protocol")
+
 // BishopSession represents an active Bishop session
 type BishopSession struct {
 	ID        string
 	Conn      net.Conn
 	StartTime time.Time
 	LastActivity time.Time
+	LastPing  time.Time
 	BishopID  [16]byte
+	out       *sender
 }
 
 // Handler handles protocol operations
 type Handler struct {
 	db             *database.Connection
+	authMode       string
+	lockout        *lockout.Tracker
+	playTime       *playtime.Tracker
+	pingCycle      time.Duration
+	maxMissedPings int
+	maxPacketSize  int
+	writeTimeout   time.Duration
+	drainUntil     atomic.Int64
+	provisional    atomic.Bool
 	bishopSessions map[string]*BishopSession
+	onlinePlayers  map[string]*OnlinePlayer
 	sessionMutex   sync.RWMutex
 }
 
+// HandlerOptions configures a protocol handler
+type HandlerOptions struct {
+	// AuthMode is one of the config.AuthMode* values and decides how logins
+	// behave without a database
+	AuthMode string
+	// Lockout may be nil to disable brute-force protection
+	Lockout *lockout.Tracker
+	// PlayTime may be nil to disable the daily play-time limit
+	PlayTime *playtime.Tracker
+	// PingCycle is the Bishop heartbeat interval (default 10s)
+	PingCycle time.Duration
+	// MaxMissedPings is how many cycles a gateway may miss before it is
+	// declared dead (default 3)
+	MaxMissedPings int
+	// MaxPacketSize caps the Size header of a received frame; larger
+	// frames close the connection (default 4096)
+	MaxPacketSize int
+	// WriteTimeout bounds each write to a gateway; a write that misses it
+	// closes the session (default 10s)
+	WriteTimeout time.Duration
+	// ProvisionalPackets allows Paysys-initiated frames that have not been
+	// checked against a capture; off, players are only kicked through the
+	// admin API and no coin notices are sent
+	ProvisionalPackets bool
+}
+
 // NewHandler creates a new protocol handler
-func NewHandler(db *database.Connection) *Handler {
-	return &Handler{
+func NewHandler(db *database.Connection, opts HandlerOptions) *Handler {
+	if opts.PingCycle <= 0 {
+		opts.PingCycle = 10 * time.Second
+	}
+	if opts.MaxMissedPings <= 0 {
+		opts.MaxMissedPings = 3
+	}
+	if opts.MaxPacketSize < minFrameSize {
+		opts.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	h := &Handler{
 		db:             db,
+		authMode:       opts.AuthMode,
+		lockout:        opts.Lockout,
+		playTime:       opts.PlayTime,
+		pingCycle:      opts.PingCycle,
+		maxMissedPings: opts.MaxMissedPings,
+		maxPacketSize:  opts.MaxPacketSize,
+		writeTimeout:   opts.WriteTimeout,
 		bishopSessions: make(map[string]*BishopSession),
+		onlinePlayers:  make(map[string]*OnlinePlayer),
 	}
+	h.SetProvisionalPackets(opts.ProvisionalPackets)
+	return h
+}
+
+// AuthMode returns the configured authentication mode
+func (h *Handler) AuthMode() string {
+	return h.authMode
+}
+
+// StoreHealthy reports whether the account store is connected and healthy
+func (h *Handler) StoreHealthy() bool {
+	return h.db != nil && h.db.Healthy()
 }
 
 // HandleConnection handles a new client connection
 func (h *Handler) HandleConnection(conn net.Conn) {
 	clientAddr := conn.RemoteAddr().String()
-	log.Printf("[Protocol] New connection from %s", clientAddr)
+	logger.Info("new connection", "addr", clientAddr)
 	
 	// Send security key immediately - Bishop expects this on connection (from working JavaScript implementation)
-	log.Printf("[Protocol] Sending security key immediately to %s (Bishop requirement)", clientAddr)
+	logger.Debug("sending security key (Bishop requirement)", "addr", clientAddr)
 	securityKeyPacket := h.createSecurityKeyPacket()
-	_, err := conn.Write(securityKeyPacket)
+	err := h.writeFrame(conn, securityKeyPacket)
 	if err != nil {
-		log.Printf("[Protocol] Failed to send security key to %s: %v", clientAddr, err)
+		logger.Warn("failed to send security key", "addr", clientAddr, "err", err)
 		conn.Close()
 		return
 	}
-	log.Printf("[Protocol] Security key sent to %s", clientAddr)
+	logger.Debug("security key sent", "addr", clientAddr)
 	
 	// Now read incoming packets and handle them
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
+	buffer := make([]byte, h.maxPacketSize)
+	conn.SetReadDeadline(h.readDeadline())
+	frame, err := readFrame(conn, buffer)
+	n := len(frame)
 	if err != nil {
-		log.Printf("[Protocol] Error reading packet from %s: %v", clientAddr, err)
+		logger.Warn("error reading packet", "addr", clientAddr, "err", err)
 		conn.Close()
 		return
 	}
 	
 	data := buffer[:n]
-	log.Printf("[Protocol] Received %d bytes from %s", n, clientAddr)
-	log.Printf("[Protocol] Raw data: %x", data)
+	logger.Debug("received packet", "addr", clientAddr, "bytes", n)
+	traceFrame("raw packet", data, "addr", clientAddr)
+	countFrame(data)
 	
 	// Handle different packet lengths and types
 	if n == 127 {
 		// Bishop packet - handle with persistent session
-		log.Printf("[Protocol] Bishop connection detected")
+		logger.Info("Bishop connection detected", "addr", clientAddr)
 		h.handleBishopPacket(conn, data, clientAddr)
 	} else if n == 229 {
 		// Player login packet (original 0x42FF format)
-		packet, err := ParsePacket(data)
+		packet, err := parseFrame(data)
 		if err != nil {
-			log.Printf("[Protocol] Error parsing packet from %s: %v", clientAddr, err)
+			logger.Warn("error parsing packet", "addr", clientAddr, "err", err)
 			conn.Close()
 			return
 		}
 		if p, ok := packet.(*UserLoginPacket); ok {
 			response := h.handleUserLogin(p, clientAddr)
 			if response != nil {
-				conn.Write(response)
+				if err := h.writeFrame(conn, response); err != nil {
+					logger.Warn("failed to send login response", "addr", clientAddr, "err", err)
+				}
 			}
 		}
 		conn.Close()
 	} else if n == 227 {
 		// Game client login packet (protocol 62 with key)
-		packet, err := ParsePacket(data)
+		packet, err := parseFrame(data)
 		if err != nil {
-			log.Printf("[Protocol] Error parsing game packet from %s: %v", clientAddr, err)
+			logger.Warn("error parsing game packet", "addr", clientAddr, "err", err)
 			conn.Close()
 			return
 		}
 		if p, ok := packet.(*GameLoginPacket); ok {
 			response := h.handleGameLogin(p, clientAddr)
 			if response != nil {
-				conn.Write(response)
+				if err := h.writeFrame(conn, response); err != nil {
+					logger.Warn("failed to send game response", "addr", clientAddr, "err", err)
+				}
 			}
 		}
 		conn.Close()
 	} else {
-		log.Printf("[Protocol] Unexpected packet length %d from %s", n, clientAddr)
+		logger.Warn("unexpected packet length", "addr", clientAddr, "bytes", n)
 		conn.Close()
 	}
 }
 
+// writeFrame writes a frame outside a Bishop session, under the write timeout
+func (h *Handler) writeFrame(conn net.Conn, frame []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(h.writeTimeout))
+	_, err := conn.Write(frame)
+	return err
+}
+
 // createSecurityKeyPacket creates the security key packet that Bishop expects
 func (h *Handler) createSecurityKeyPacket() []byte {
 	// From working JavaScript implementation:
@@ -122,13 +214,12 @@ func (h *Handler) createSecurityKeyPacket() []byte {
 }
 
 func (h *Handler) handleBishopPacket(conn net.Conn, data []byte, clientAddr string) {
-	log.Printf("[Protocol] Bishop packet received: %d bytes", len(data))
-	log.Printf("[Protocol] First 8 bytes: %x", data[:8])
+	logger.Debug("Bishop packet received", "addr", clientAddr, "bytes", len(data))
 	
 	if len(data) == 127 {
 		// Both 127-byte Bishop packets get same response from original Linux paysys
 		protocol := binary.LittleEndian.Uint16(data[2:4])
-		log.Printf("[Protocol] 127-byte Bishop packet, protocol: 0x%x", protocol)
+		logger.Debug("127-byte Bishop packet", "addr", clientAddr, "protocol", fmt.Sprintf("0x%x", protocol))
 		
 		// From working JavaScript implementation: exact PCAP response 53 bytes
 		response := []byte{
@@ -143,307 +234,177 @@ func (h *Handler) handleBishopPacket(conn net.Conn, data []byte, clientAddr stri
 			0x00, 0xfb, 0x40, 0xa1, 0x99, 0x32, 0xca, 0x39, 0xdb,
 		}
 		
-		_, err := conn.Write(response)
+		err := h.writeFrame(conn, response)
 		if err != nil {
-			log.Printf("[Protocol] Failed to send Bishop response to %s: %v", clientAddr, err)
+			logger.Warn("failed to send Bishop response", "addr", clientAddr, "err", err)
 			conn.Close()
 			return
 		}
-		log.Printf("[Protocol] Sent exact PCAP response: %d bytes", len(response))
-		log.Printf("[Protocol] Response matches working PCAP capture exactly - should pass all Bishop checks")
+		logger.Debug("sent PCAP Bishop response", "addr", clientAddr, "bytes", len(response))
 		
 		// Keep connection alive for more packets - Bishop needs persistent session
 		h.handleBishopSession(conn, clientAddr)
 	} else {
-		log.Printf("[Protocol] Unexpected Bishop packet length: %d", len(data))
+		logger.Warn("unexpected Bishop packet length", "addr", clientAddr, "bytes", len(data))
 		conn.Close()
 	}
 }
 
 func (h *Handler) handleBishopSession(conn net.Conn, clientAddr string) {
-	log.Printf("[Protocol] Bishop session established for %s", clientAddr)
+	logger.Info("Bishop session established", "addr", clientAddr)
+	
+	// Every frame to this gateway, replies and server-initiated messages
+	// alike, goes through one ordered queue
+	out := newSender(conn, h.writeTimeout)
+	now := time.Now()
+	h.registerSession(&BishopSession{
+		ID:           clientAddr,
+		Conn:         conn,
+		StartTime:    now,
+		LastActivity: now,
+		LastPing:     now,
+		out:          out,
+	})
+	defer h.unregisterSession(clientAddr)
 	
 	// Keep reading for additional packets and handle session state
-	buffer := make([]byte, 4096)
+	buffer := make([]byte, h.maxPacketSize)
+	ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
 	
 	for {
-		// Set a longer timeout for Bishop sessions
-		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-		n, err := conn.Read(buffer)
+		// Bishop pings every PingCycle, so silence for MaxMissedPings cycles
+		// means the gateway is gone
+		conn.SetReadDeadline(h.readDeadline())
+		frame, err := readFrame(conn, buffer)
+		n := len(frame)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				log.Printf("[Protocol] Bishop session %s timeout (no activity for 5 minutes)", clientAddr)
+			if writeErr := out.Err(); writeErr != nil {
+				logger.Info("Bishop session ended after write failure", "session", clientAddr, "err", writeErr)
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() && h.Draining() {
+				logger.Info("Bishop session closed for shutdown", "session", clientAddr)
+			} else if ok && netErr.Timeout() {
+				logger.Warn("Bishop gateway dead, missed ping cycles", "session", clientAddr, "missed", h.maxMissedPings)
 			} else {
-				log.Printf("[Protocol] Bishop session %s ended: %v", clientAddr, err)
+				logger.Info("Bishop session ended", "session", clientAddr, "err", err)
 			}
 			break
 		}
 		
-		if n > 0 {
-			data := buffer[:n]
-			log.Printf("[Protocol] Bishop session %s packet: %d bytes", clientAddr, n)
-			log.Printf("[Protocol] Data: %x", data)
-			
-			// Handle different packet types during Bishop session
-			if n == 127 {
-				// Re-authentication or other Bishop commands - use same response
-				log.Printf("[Protocol] Bishop re-authentication in session %s", clientAddr)
-				response := []byte{
-					0x35, 0x00, 0x97, 0x44,
-					0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4, 
-					0x00, 0xfa, 0x40, 0xa1, 0x99, 0xa1, 
-					0x37, 0x44, 0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4,
-					0x00, 0xfa, 0x40, 0xa1, 0x99, 0xa1,
-					0x37, 0x44, 0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4,
-					0x00, 0xfb, 0x40, 0xa1, 0x99, 0x32, 0xca, 0x39, 0xdb,
-				}
-				conn.Write(response)
-			} else if n == 227 {
-				// Game client login packet during Bishop session
-				log.Printf("[Protocol] Game login packet in Bishop session %s", clientAddr)
-				packet, err := ParsePacket(data)
-				if err != nil {
-					log.Printf("[Protocol] Error parsing game packet in Bishop session: %v", err)
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				} else if p, ok := packet.(*GameLoginPacket); ok {
-					response := h.handleGameLogin(p, clientAddr)
-					if response != nil {
-						conn.Write(response)
-					}
-				} else {
-					log.Printf("[Protocol] Failed to cast to GameLoginPacket in Bishop session")
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				}
-			} else if n == 229 {
-				// 229-byte packet during Bishop session - could be user login (0x42ff) or player identity verification (0xe0ff)
-				packet, err := ParsePacket(data)
-				if err != nil {
-					log.Printf("[Protocol] Error parsing 229-byte packet in Bishop session: %v", err)
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				} else if p, ok := packet.(*UserLoginPacket); ok {
-					// Protocol 0x42ff - traditional user login
-					log.Printf("[Protocol] User login packet (0x42ff) in Bishop session %s", clientAddr)
-					response := h.handleUserLogin(p, clientAddr)
-					if response != nil {
-						conn.Write(response)
-					}
-				} else if p, ok := packet.(*GameLoginPacket); ok {
-					// Protocol 0xe0ff - player identity verification (matches JavaScript implementation)
-					log.Printf("[Protocol] Player identity verification packet (0xe0ff) in Bishop session %s", clientAddr)
-					response := h.handlePlayerIdentityVerification(p, clientAddr)
-					if response != nil {
-						conn.Write(response)
-					}
-				} else {
-					log.Printf("[Protocol] Failed to cast 229-byte packet to known type in Bishop session")
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				}
-			} else if n == 47 {
-				// Session confirmation packet (0x14ff) - comes after player identity verification
-				log.Printf("[Protocol] Session confirmation packet in Bishop session %s", clientAddr)
-				packet, err := ParsePacket(data)
-				if err != nil {
-					log.Printf("[Protocol] Error parsing session confirmation packet: %v", err)
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				} else if p, ok := packet.(*SessionConfirmPacket); ok {
-					response := h.handleSessionConfirm(p, clientAddr)
-					if response != nil {
-						conn.Write(response)
-					}
-				} else {
-					log.Printf("[Protocol] Failed to cast to SessionConfirmPacket in Bishop session")
-					ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-					conn.Write(ackResponse)
-				}
-			} else if n == 7 {
-				// Short Bishop packets - likely ping or simple commands
-				log.Printf("[Protocol] Short Bishop packet in session %s, sending ACK", clientAddr)
-				ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK
-				conn.Write(ackResponse)
-			} else {
-				log.Printf("[Protocol] Unknown packet type in Bishop session %s, sending ACK", clientAddr)
-				// Send a simple acknowledgment for unknown packets
-				ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-				conn.Write(ackResponse)
+		data := buffer[:n]
+		logger.Debug("Bishop session packet", "session", clientAddr, "bytes", n)
+		traceFrame("Bishop session packet", data, "session", clientAddr)
+		countFrame(data)
+		h.touchSession(clientAddr, false)
+		
+		// Handle different packet types during Bishop session
+		var response []byte
+		if n == 127 {
+			// Re-authentication or other Bishop commands - use same response
+			logger.Info("Bishop re-authentication", "session", clientAddr)
+			response = []byte{
+				0x35, 0x00, 0x97, 0x44,
+				0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4, 
+				0x00, 0xfa, 0x40, 0xa1, 0x99, 0xa1, 
+				0x37, 0x44, 0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4,
+				0x00, 0xfa, 0x40, 0xa1, 0x99, 0xa1,
+				0x37, 0x44, 0x61, 0x37, 0xcc, 0x16, 0x16, 0xb0, 0x5d, 0xd4,
+				0x00, 0xfb, 0x40, 0xa1, 0x99, 0x32, 0xca, 0x39, 0xdb,
 			}
-		}
-	}
-	
-	log.Printf("[Protocol] Bishop session %s ended, closing connection", clientAddr)
-	conn.Close()
-}
-
-func (h *Handler) handleBishopConnection(conn net.Conn, packet *BishopLoginPacket, clientAddr string) {
-	defer conn.Close() // Ensure connection is closed when this function exits
-	
-	log.Printf("[Protocol] Bishop login from %s", clientAddr)
-	log.Printf("[Protocol] Bishop ID: %x", packet.BishopID)
-	log.Printf("[Protocol] Unknown fields: %08x %08x %08x", packet.Unknown1, packet.Unknown2, packet.Unknown3)
-	
-	// Create and register Bishop session
-	sessionID := clientAddr // Use client address as session ID for now
-	session := &BishopSession{
-		ID:           sessionID,
-		Conn:         conn,
-		StartTime:    time.Now(),
-		LastActivity: time.Now(),
-		BishopID:     packet.BishopID,
-	}
-	
-	h.sessionMutex.Lock()
-	h.bishopSessions[sessionID] = session
-	h.sessionMutex.Unlock()
-	
-	defer func() {
-		// Clean up session when connection ends
-		h.sessionMutex.Lock()
-		delete(h.bishopSessions, sessionID)
-		h.sessionMutex.Unlock()
-		log.Printf("[Protocol] Bishop session %s cleaned up", sessionID)
-	}()
-	
-	// Send Bishop authentication response
-	response := CreateBishopResponse(0) // 0 = success
-	_, err := conn.Write(response)
-	if err != nil {
-		log.Printf("[Protocol] Error sending Bishop response to %s: %v", clientAddr, err)
-		return
-	}
-	log.Printf("[Protocol] Sent %d bytes Bishop response to %s", len(response), clientAddr)
-	log.Printf("[Protocol] Response data: %x", response)
-	
-	// Bishop session established
-	log.Printf("[Protocol] Bishop session %s established, managing connection for %s", sessionID, clientAddr)
-	
-	// Bishop connections require persistent session management
-	// Keep reading for additional packets and handle session state
-	buffer := make([]byte, 4096)
-	sessionActive := true
-	
-	for sessionActive {
-		// Set a longer timeout for Bishop sessions - they may have periods of inactivity
-		conn.SetReadDeadline(time.Now().Add(5 * time.Minute)) // 5 minute timeout
-		n, err := conn.Read(buffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				log.Printf("[Protocol] Bishop session %s timeout (no activity for 5 minutes)", sessionID)
+		} else if n == 227 {
+			// Game client login packet during Bishop session
+			logger.Debug("game login packet in Bishop session", "session", clientAddr)
+			packet, err := parseFrame(data)
+			if err != nil {
+				logger.Warn("error parsing game packet in Bishop session", "session", clientAddr, "err", err)
+				response = ackResponse
+			} else if p, ok := packet.(*GameLoginPacket); ok {
+				response = h.handleGameLogin(p, clientAddr)
 			} else {
-				log.Printf("[Protocol] Bishop session %s ended: %v", sessionID, err)
+				logger.Warn("failed to cast to GameLoginPacket in Bishop session", "session", clientAddr)
+				response = ackResponse
 			}
-			sessionActive = false
-			break
-		}
-		
-		if n > 0 {
-			// Update session activity
-			h.sessionMutex.Lock()
-			if sess, exists := h.bishopSessions[sessionID]; exists {
-				sess.LastActivity = time.Now()
+		} else if n == 229 {
+			// 229-byte packet during Bishop session - could be user login (0x42ff) or player identity verification (0xe0ff)
+			packet, err := parseFrame(data)
+			if err != nil {
+				logger.Warn("error parsing 229-byte packet in Bishop session", "session", clientAddr, "err", err)
+				response = ackResponse
+			} else if p, ok := packet.(*UserLoginPacket); ok {
+				// Protocol 0x42ff - traditional user login
+				logger.Debug("user login packet (0x42ff) in Bishop session", "session", clientAddr)
+				response = h.handleUserLogin(p, clientAddr)
+			} else if p, ok := packet.(*GameLoginPacket); ok {
+				// Protocol 0xe0ff - player identity verification (matches JavaScript implementation)
+				logger.Debug("player identity verification packet (0xe0ff) in Bishop session", "session", clientAddr)
+				response = h.handlePlayerIdentityVerification(p, clientAddr)
+			} else {
+				logger.Warn("failed to cast 229-byte packet to known type in Bishop session", "session", clientAddr)
+				response = ackResponse
 			}
-			h.sessionMutex.Unlock()
-			
-			data := buffer[:n]
-			log.Printf("[Protocol] Bishop session %s packet: %d bytes", sessionID, n)
-			log.Printf("[Protocol] Data: %x", data)
-			
-			// Parse and handle session packets
-			sessionPacket, err := ParsePacket(data)
+		} else if n == 47 {
+			// Session confirmation packet (0x14ff) - comes after player identity verification
+			logger.Debug("session confirmation packet in Bishop session", "session", clientAddr)
+			packet, err := parseFrame(data)
 			if err != nil {
-				log.Printf("[Protocol] Error parsing Bishop session packet: %v", err)
-				// Don't break session for parsing errors, just log and continue
-				continue
+				logger.Warn("error parsing session confirmation packet", "session", clientAddr, "err", err)
+				response = ackResponse
+			} else if p, ok := packet.(*SessionConfirmPacket); ok {
+				response = h.handleSessionConfirm(p, clientAddr)
+			} else {
+				logger.Warn("failed to cast to SessionConfirmPacket in Bishop session", "session", clientAddr)
+				response = ackResponse
 			}
-			
-			// Handle different packet types during Bishop session
-			switch sp := sessionPacket.(type) {
-			case *BishopLoginPacket:
-				// Re-authentication request - respond with success
-				log.Printf("[Protocol] Bishop re-authentication in session %s", sessionID)
-				reAuthResponse := CreateBishopResponse(0)
-				_, err := conn.Write(reAuthResponse)
-				if err != nil {
-					log.Printf("[Protocol] Error sending Bishop re-auth response: %v", err)
-					sessionActive = false
-				}
-			case *UserLoginPacket:
-				// User login request via Bishop connection
-				log.Printf("[Protocol] User login via Bishop session %s", sessionID)
-				userResponse := h.handleUserLogin(sp, clientAddr)
-				if userResponse != nil {
-					_, err := conn.Write(userResponse)
-					if err != nil {
-						log.Printf("[Protocol] Error sending user login response via Bishop: %v", err)
-						sessionActive = false
-					}
-				}
-			case *GameLoginPacket:
-				// Game login request via Bishop connection
-				log.Printf("[Protocol] Game login via Bishop session %s", sessionID)
-				gameResponse := h.handleGameLogin(sp, clientAddr)
-				if gameResponse != nil {
-					_, err := conn.Write(gameResponse)
-					if err != nil {
-						log.Printf("[Protocol] Error sending game login response via Bishop: %v", err)
-						sessionActive = false
-					}
-				}
-			default:
-				log.Printf("[Protocol] Unknown packet type in Bishop session %s, sending ACK", sessionID)
-				// Send a simple acknowledgment for unknown packets
-				ackResponse := []byte{0x04, 0x00, 0x01, 0x00} // 4-byte ACK packet
-				conn.Write(ackResponse)
+		} else if n == PingPacketSize {
+			// Heartbeat sent every PingCycle seconds
+			if err := h.answerPing(clientAddr); err != nil {
+				logger.Warn("failed to queue ping response", "session", clientAddr, "err", err)
+				break
+			}
+		} else {
+			logger.Debug("unknown packet type in Bishop session, sending ACK", "session", clientAddr)
+			// Send a simple acknowledgment for unknown packets
+			response = ackResponse
+		}
+		
+		if response != nil {
+			if err := out.Send(response); err != nil {
+				logger.Warn("failed to queue response, closing session", "session", clientAddr, "err", err)
+				break
 			}
 		}
 	}
 	
-	log.Printf("[Protocol] Bishop session %s ended", sessionID)
-}
-
-func (h *Handler) handleBishopLogin(packet *BishopLoginPacket, clientAddr string) []byte {
-	log.Printf("[Protocol] Bishop login from %s", clientAddr)
-	log.Printf("[Protocol] Bishop ID: %x", packet.BishopID)
-	log.Printf("[Protocol] Unknown fields: %08x %08x %08x", packet.Unknown1, packet.Unknown2, packet.Unknown3)
-	
-	// For now, always accept Bishop connections
-	// In a real implementation, you'd verify the Bishop ID against a whitelist
-	response := CreateBishopResponse(0) // 0 = success
-	log.Printf("[Protocol] Bishop login accepted for %s", clientAddr)
-	
-	return response
+	logger.Info("Bishop session ended, closing connection", "session", clientAddr)
+	out.Close()
+	conn.Close()
 }
 
 func (h *Handler) handleGameLogin(packet *GameLoginPacket, clientAddr string) []byte {
-	log.Printf("[Protocol] Game login from %s", clientAddr)
-	log.Printf("[Protocol] Protocol: 0x%x, Key: %d, Size: %d", packet.Header.Type, packet.Header.Key, packet.Header.Size)
-	log.Printf("[Protocol] Data (%d bytes): %x", len(packet.Data), packet.Data)
+	logger.Debug("game login", "addr", clientAddr, "protocol", fmt.Sprintf("0x%x", uint16(packet.Header.Type)), "key", packet.Header.Key, "size", packet.Header.Size)
+	logging.TracePacket(logger, "game login data", packet.Data, "addr", clientAddr)
 	
 	// For game login packets, we typically just need to respond with success
 	// The actual authentication was already done via Bishop
 	// Game is just verifying the paysys connection is working
 	
-	log.Printf("[Protocol] Game login verification successful for %s", clientAddr)
+	logger.Info("game login verification successful", "addr", clientAddr)
 	
 	// Always use the request key for the response - this should fix the key mismatch issue
 	responseKey := packet.Header.Key
-	log.Printf("[Protocol] Using request key %d for response", responseKey)
+	logger.Debug("using request key for response", "key", responseKey)
 	
 	// Create minimal response with matching key (protocol 254, size 2, key matching request)  
 	// This matches the expected "Protocol = 254; Size = 2; Key = X" format from the error log
 	response := CreateGameResponse(responseKey, 0, []byte{}) // Success with no additional data
-	log.Printf("[Protocol] Sending game response: Protocol=%d, Size=%d, Key=%d", PacketTypeGameResponse, len(response), responseKey)
+	logger.Debug("sending game response", "protocol", uint16(PacketTypeGameResponse), "size", len(response), "key", responseKey)
 	
 	return response
 }
 
 func (h *Handler) handlePlayerIdentityVerification(packet *GameLoginPacket, clientAddr string) []byte {
-	log.Printf("[Protocol] Player identity verification from %s", clientAddr)
-	log.Printf("[Protocol] Protocol: 0x%x, Size: %d", packet.Header.Type, packet.Header.Size)
-	log.Printf("[Protocol] Full packet data: %x", packet.Data)
+	logger.Info("player identity verification", "addr", clientAddr)
+	logger.Debug("player identity verification header", "protocol", fmt.Sprintf("0x%x", uint16(packet.Header.Type)), "size", packet.Header.Size)
+	logging.TracePacket(logger, "player identity verification data", packet.Data, "addr", clientAddr)
 	
 	// Based on working JavaScript implementation - this is for protocol 0xe0ff packets
 	// Should respond with exact PCAP format: 169 bytes with protocol 0xa8ff
@@ -476,86 +437,148 @@ func (h *Handler) handlePlayerIdentityVerification(packet *GameLoginPacket, clie
 		0x57, 0x5c, 0x67, 0x61, 0xc1,
 	}
 	
-	log.Printf("[Protocol] Sending exact PCAP player identity verification response: %d bytes", len(response))
-	log.Printf("[Protocol] Response protocol: 0x%x (should be 0xa8ff)", uint16(response[3])<<8|uint16(response[2]))
+	logger.Debug("sending PCAP player identity verification response", "bytes", len(response), "protocol", fmt.Sprintf("0x%x", uint16(response[3])<<8|uint16(response[2])))
 	
 	return response
 }
 
 func (h *Handler) handleSessionConfirm(packet *SessionConfirmPacket, clientAddr string) []byte {
-	log.Printf("[Protocol] Session confirmation from %s", clientAddr)
-	log.Printf("[Protocol] Protocol: 0x%x, Size: %d", packet.Header.Type, packet.Header.Size)
-	log.Printf("[Protocol] Session data (%d bytes): %x", len(packet.Data), packet.Data)
+	logger.Info("session confirmation", "addr", clientAddr)
+	logger.Debug("session confirmation header", "protocol", fmt.Sprintf("0x%x", uint16(packet.Header.Type)), "size", packet.Header.Size)
+	logging.TracePacket(logger, "session confirmation data", packet.Data, "addr", clientAddr)
 	
 	// Create session confirmation response
 	response := CreateSessionConfirmResponse()
-	log.Printf("[Protocol] Sending session confirmation response: %d bytes", len(response))
+	logger.Debug("sending session confirmation response", "bytes", len(response))
 	
 	return response
 }
 
 func (h *Handler) handleUserLogin(packet *UserLoginPacket, clientAddr string) []byte {
-	log.Printf("[Protocol] User login from %s", clientAddr)
-	log.Printf("[Protocol] Encrypted data (%d bytes): %x", len(packet.EncryptedData), packet.EncryptedData)
+	// The payload is only XOR-obfuscated with a fixed key, so it is never
+	// dumped, not even with packet tracing on
+	logger.Debug("user login", "addr", clientAddr, "bytes", len(packet.EncryptedData))
 	
 	// Decrypt the login data
 	decryptedData := DecryptXOR(packet.EncryptedData)
-	log.Printf("[Protocol] Decrypted data: %x", decryptedData)
-	log.Printf("[Protocol] Decrypted as string: %q", string(decryptedData))
 	
 	// Parse username and password
 	username, password, err := ParseLoginData(decryptedData)
 	if err != nil {
-		log.Printf("[Protocol] Error parsing login data from %s: %v", clientAddr, err)
-		response := CreateEncryptedLoginResponse(1, "Failed to parse login data")
+		logger.Warn("error parsing login data", "addr", clientAddr, "err", err)
+		parseErrorsTotal.Inc()
+		response := loginResponse(LoginResultParseError, "Failed to parse login data")
 		return response
 	}
 	
-	log.Printf("[Protocol] Login attempt - Username: %s, Password: %s", username, password)
+	logger.Info("login attempt", "username", username, "addr", clientAddr)
 	
-	// Verify credentials against database
-	if h.db != nil {
-		isValid, err := h.db.AccountLogin(username, password)
-		if err != nil {
-			log.Printf("[Protocol] Database error for %s: %v", username, err)
-			response := CreateEncryptedLoginResponse(2, "Database error")
+	// Reject early while the account or source is in brute-force cool-down
+	sourceIP := hostOf(clientAddr)
+	if blocked, until := h.lockout.Blocked(username, sourceIP); blocked && h.authMode != config.AuthModeDevAcceptAll {
+		logger.Warn("login blocked by lockout", "username", username, "addr", clientAddr, "until", until.Format(time.RFC3339))
+		response := loginResponse(LoginResultTooManyTries, "Too many failed attempts")
+		return response
+	}
+	
+	// Verify credentials and account state against database in one query
+	switch {
+	case h.authMode == config.AuthModeDevAcceptAll:
+		// Development mode - accept all logins without touching the database
+		logger.Warn("dev-accept-all mode, accepting login", "username", username)
+	case !h.StoreHealthy():
+		if h.authMode != config.AuthModeStoreFallback {
+			// Strict mode fails closed while the database is down
+			logger.Warn("database unavailable, rejecting login", "username", username)
+			response := loginResponse(LoginResultDatabaseError, "Database error")
 			return response
 		}
-		
-		if !isValid {
-			log.Printf("[Protocol] Invalid credentials for %s from %s", username, clientAddr)
-			response := CreateEncryptedLoginResponse(3, "Invalid credentials")
+		logger.Warn("database unavailable, store-fallback mode accepting login", "username", username)
+	default:
+		result, err := h.db.VerifyLogin(context.Background(), username, password)
+		if err != nil {
+			logger.Error("database error during login", "username", username, "err", err)
+			response := loginResponse(LoginResultDatabaseError, "Database error")
 			return response
 		}
 		
-		// Check account locked state (0 = not locked, 1 = locked)
-		lockedState, err := h.db.GetAccountState(username)
-		if err != nil {
-			log.Printf("[Protocol] Error getting account state for %s: %v", username, err)
-			response := CreateEncryptedLoginResponse(2, "Account state error")
-			return response
+		switch result {
+		case database.LoginOK:
+			h.lockout.RecordSuccess(username)
+		case database.LoginWrongPassword, database.LoginNotFound:
+			h.lockout.RecordFailure(context.Background(), username, sourceIP)
 		}
 		
-		if lockedState != 0 {
-			log.Printf("[Protocol] Account %s is locked (locked: %d)", username, lockedState)
-			response := CreateEncryptedLoginResponse(4, "Account locked")
+		if result != database.LoginOK {
+			code, message := loginResultCode(result)
+			logger.Info("login rejected", "username", username, "addr", clientAddr, "result", result.String())
+			response := loginResponse(code, message)
 			return response
 		}
-	} else {
-		// No database mode - accept all logins for testing
-		log.Printf("[Protocol] No database mode - accepting login for %s", username)
 	}
 	
-	log.Printf("[Protocol] Login successful for %s from %s", username, clientAddr)
-	response := CreateEncryptedLoginResponse(0, "Login successful")
+	// Only valid credentials are told the allowance is used up
+	h.endRelogin(username, clientAddr)
+	if allowed, used := h.playTime.Allow(context.Background(), username); !allowed {
+		logger.Info("login rejected, daily play time used up", "username", username, "addr", clientAddr, "used", used.Round(time.Second))
+		response := loginResponse(LoginResultPlayTimeLimit, "Daily play time used up")
+		return response
+	}
+	
+	logger.Info("login successful", "username", username, "addr", clientAddr)
+	h.addOnlinePlayer(username, clientAddr, clientAddr)
+	response := loginResponse(LoginResultSuccess, "Login successful")
 	return response
 }
 
-// HandlePing handles ping packets to keep connections alive
-func (h *Handler) HandlePing(conn net.Conn) {
-	// Simple ping response - just echo back
-	response := []byte{0x01, 0x00, 0x00, 0x00} // Simple ping response
-	conn.Write(response)
+// loginResultCode maps a store login result to the wire result code and message
+func loginResultCode(result database.LoginResult) (uint8, string) {
+	switch result {
+	case database.LoginOK:
+		return LoginResultSuccess, "Login successful"
+	case database.LoginNotFound:
+		return LoginResultNotFound, "Account not found"
+	case database.LoginWrongPassword:
+		return LoginResultWrongPassword, "Invalid credentials"
+	case database.LoginInactive:
+		return LoginResultInactive, "Account not activated"
+	case database.LoginLocked:
+		return LoginResultLocked, "Account locked"
+	case database.LoginNewLocked:
+		return LoginResultNewLocked, "Account frozen"
+	case database.LoginTimedLocked:
+		return LoginResultTimedLocked, "Account temporarily locked"
+	case database.LoginTooManyAttempts:
+		return LoginResultTooManyTries, "Too many failed attempts"
+	default:
+		return LoginResultDatabaseError, "Database error"
+	}
+}
+
+// traceFrame dumps a received frame when packet tracing is enabled. User login
+// frames carry the credentials under a fixed XOR key, so only their header is
+// dumped.
+func traceFrame(msg string, data []byte, args ...any) {
+	if len(data) >= 4 && PacketType(binary.LittleEndian.Uint16(data[2:4])) == PacketTypeUserLogin {
+		data = data[:4]
+	}
+	logging.TracePacket(logger, msg, data, args...)
+}
+
+// hostOf strips the port from a remote address
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// answerPing acknowledges a Bishop heartbeat and records it as the session's
+// last ping
+func (h *Handler) answerPing(sessionID string) error {
+	h.touchSession(sessionID, true)
+	return h.SendToGateway(sessionID, CreatePingAck())
 }
 
 // GetActiveBishopSessions returns information about active Bishop sessions
@@ -569,6 +592,7 @@ func (h *Handler) GetActiveBishopSessions() map[string]*BishopSession {
 			ID:           session.ID,
 			StartTime:    session.StartTime,
 			LastActivity: session.LastActivity,
+			LastPing:     session.LastPing,
 			BishopID:     session.BishopID,
 			// Don't copy the connection object for safety
 		}
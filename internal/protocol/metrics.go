@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"jx2-paysys/internal/metrics"
+)
+
+var (
+	loginsTotal = metrics.NewCounterVec("paysys_logins_total",
+		"User logins answered, by result code.", "result", loginResultLabels()...)
+	packetsTotal = metrics.NewCounterVec("paysys_packets_total",
+		"Frames received from gateways, by packet type.", "type", packetTypeLabels()...)
+	parseErrorsTotal = metrics.NewCounter("paysys_parse_errors_total",
+		"Frames or login payloads that failed to parse.")
+	playTimeKicksTotal = metrics.NewCounter("paysys_play_time_kicks_total",
+		"Players kicked for using up their daily play time.")
+)
+
+// loginResultNames label paysys_logins_total by wire result code
+var loginResultNames = map[uint8]string{
+	LoginResultSuccess:       "success",
+	LoginResultParseError:    "parse_error",
+	LoginResultDatabaseError: "database_error",
+	LoginResultWrongPassword: "wrong_password",
+	LoginResultLocked:        "locked",
+	LoginResultNotFound:      "not_found",
+	LoginResultInactive:      "inactive",
+	LoginResultNewLocked:     "newlocked",
+	LoginResultTimedLocked:   "timed_locked",
+	LoginResultTooManyTries:  "too_many_tries",
+	LoginResultPlayTimeLimit: "play_time_limit",
+}
+
+// knownPacketTypes are labelled by value; anything else is "unknown" so a
+// misbehaving gateway cannot create unbounded series
+var knownPacketTypes = map[PacketType]bool{
+	PacketTypeBishopLogin: true, PacketTypeBishopLoginAlt: true,
+	PacketTypeUserLogin: true, PacketTypeGameLogin: true, PacketTypeGameLoginAlt: true,
+	PacketTypeSessionConfirm: true,
+}
+
+// loginResultLabels lists the result label of every known login result code
+func loginResultLabels() []string {
+	labels := make([]string, 0, len(loginResultNames))
+	for _, name := range loginResultNames {
+		labels = append(labels, name)
+	}
+	return labels
+}
+
+// packetTypeLabels lists the type labels countFrame uses
+func packetTypeLabels() []string {
+	labels := []string{"ping", "unknown"}
+	for t := range knownPacketTypes {
+		labels = append(labels, packetTypeLabel(t))
+	}
+	return labels
+}
+
+func packetTypeLabel(t PacketType) string {
+	return fmt.Sprintf("0x%04X", uint16(t))
+}
+
+// countFrame records a received frame in paysys_packets_total. Pings carry no
+// stable type, so they are counted by their size.
+func countFrame(frame []byte) {
+	if len(frame) < minFrameSize {
+		return
+	}
+	if len(frame) == PingPacketSize {
+		packetsTotal.Inc("ping")
+		return
+	}
+	t := PacketType(binary.LittleEndian.Uint16(frame[2:4]))
+	if !knownPacketTypes[t] {
+		packetsTotal.Inc("unknown")
+		return
+	}
+	packetsTotal.Inc(packetTypeLabel(t))
+}
+
+// parseFrame is ParsePacket counting failures
+func parseFrame(data []byte) (interface{}, error) {
+	packet, err := ParsePacket(data)
+	if err != nil {
+		parseErrorsTotal.Inc()
+	}
+	return packet, err
+}
+
+// loginResponse builds the encrypted login reply and counts its result
+func loginResponse(code uint8, message string) []byte {
+	name, ok := loginResultNames[code]
+	if !ok {
+		name = metrics.OtherLabel
+	}
+	loginsTotal.Inc(name)
+	return CreateEncryptedLoginResponse(code, message)
+}
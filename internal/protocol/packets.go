@@ -34,11 +34,35 @@ const (
 	PacketTypeItemBuy        PacketType = 0x0004
 	PacketTypeItemUse        PacketType = 0x0005
 	PacketTypeCoinQuery      PacketType = 0x0006
-	PacketTypeCoinUpdate     PacketType = 0x0007
+	PacketTypeCoinUpdate     PacketType = 0x0007  // Also sent Paysys -> Bishop as a balance notice, provisional
 	PacketTypeAccountInfo    PacketType = 0x0008
 	PacketTypePasswordChange PacketType = 0x0009
 	PacketTypeAccountLock    PacketType = 0x000A
 	PacketTypeAccountUnlock  PacketType = 0x000B
+	PacketTypeKickAccount    PacketType = 0x000C  // Paysys -> Bishop, provisional: no capture has one yet
+)
+
+// Kick reasons carried in the kick account request
+const (
+	KickReasonAdmin          uint8 = 0
+	KickReasonBanned         uint8 = 1
+	KickReasonDuplicateLogin uint8 = 2
+	KickReasonPlayTimeLimit  uint8 = 3
+)
+
+// Login result codes carried in the first byte of the 0xA8FF response
+const (
+	LoginResultSuccess       uint8 = 0
+	LoginResultParseError    uint8 = 1
+	LoginResultDatabaseError uint8 = 2
+	LoginResultWrongPassword uint8 = 3
+	LoginResultLocked        uint8 = 4
+	LoginResultNotFound      uint8 = 5
+	LoginResultInactive      uint8 = 6
+	LoginResultNewLocked     uint8 = 7
+	LoginResultTimedLocked   uint8 = 8
+	LoginResultTooManyTries  uint8 = 9
+	LoginResultPlayTimeLimit uint8 = 10
 )
 
 // PacketHeader represents the common packet header
@@ -96,6 +120,13 @@ type CoinUpdatePacket struct {
 	Type     uint8    // Update type: 0=set, 1=add, 2=subtract
 }
 
+// KickAccountPacket asks a Bishop to disconnect a player
+type KickAccountPacket struct {
+	Header   PacketHeader
+	Username [32]byte // Account to disconnect
+	Reason   uint8    // One of the KickReason* values
+}
+
 // AccountInfoPacket represents account information request
 type AccountInfoPacket struct {
 	Header PacketHeader
@@ -251,6 +282,42 @@ func parseUserLoginPacket(data []byte) (*UserLoginPacket, error) {
 	return packet, nil
 }
 
+// CreateKickAccountRequest creates a paysys-initiated request for the owning
+// Bishop to disconnect username
+func CreateKickAccountRequest(username string, reason uint8) []byte {
+	packet := KickAccountPacket{
+		Header: PacketHeader{
+			Size: 37, // header(4) + username(32) + reason(1)
+			Type: PacketTypeKickAccount,
+		},
+		Reason: reason,
+	}
+	copy(packet.Username[:], username)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, packet)
+	return buf.Bytes()
+}
+
+// CreateCoinBalanceNotice creates a paysys-initiated coin update telling the
+// owning Bishop an account's new balance, so the game can refresh it without
+// a relog
+func CreateCoinBalanceNotice(username string, coin int64) []byte {
+	packet := CoinUpdatePacket{
+		Header: PacketHeader{
+			Size: 45, // header(4) + username(32) + amount(8) + type(1)
+			Type: PacketTypeCoinUpdate,
+		},
+		Amount: coin,
+		Type:   0, // Set
+	}
+	copy(packet.Username[:], username)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, packet)
+	return buf.Bytes()
+}
+
 // CreateLoginResponse creates a login response packet
 func CreateLoginResponse(result uint8, additionalData []byte) []byte {
 	header := PacketHeader{
@@ -287,6 +354,19 @@ func CreateBishopResponse(result uint8) []byte {
 	return buf.Bytes()
 }
 
+// PingPacketSize is the length of the Bishop heartbeat frame. Bishop sends
+// one every PingCycle seconds.
+const PingPacketSize = 7
+
+// CreatePingAck creates the reply to a Bishop heartbeat: the same 4-byte ACK
+// paysys sends for other short Bishop frames. The original paysys answers
+// with a 7-byte frame whose body is its Unix time XORed with a 32-bit value
+// fixed per connection (see PROTOCOL.md); how that value follows from the
+// security key is not known, so it cannot be reproduced yet.
+func CreatePingAck() []byte {
+	return []byte{0x04, 0x00, 0x01, 0x00}
+}
+
 // CreateSessionConfirmResponse creates a session confirmation response
 func CreateSessionConfirmResponse() []byte {
 	// Create a simple success response for session confirmation
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"jx2-paysys/internal/logging"
+)
+
+// sendQueueSize is how many frames may wait for a slow gateway before the
+// session is torn down
+const sendQueueSize = 64
+
+var (
+	// ErrUnknownGateway is returned when no Bishop session has the given ID
+	ErrUnknownGateway = errors.New("unknown gateway session")
+	// ErrSessionClosed is returned when the session's writer has stopped
+	ErrSessionClosed = errors.New("gateway session closed")
+	// ErrSendQueueFull is returned when a gateway stopped reading; the
+	// session is closed
+	ErrSendQueueFull = errors.New("gateway send queue full")
+	// ErrPlayerOffline is returned when an account is not online through
+	// any Bishop session
+	ErrPlayerOffline = errors.New("player not online")
+	// ErrProvisionalDisabled is returned for a provisional Paysys-initiated
+	// frame while ProvisionalPackets is off
+	ErrProvisionalDisabled = errors.New("provisional packets disabled")
+)
+
+// sender writes frames to one gateway connection from a single goroutine, in
+// the order they were queued. The first write error closes the connection,
+// which ends the session's read loop.
+type sender struct {
+	conn         net.Conn
+	writeTimeout time.Duration
+	queue        chan []byte
+	done         chan struct{}
+	stopped      chan struct{}
+	once         sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// newSender starts the writer goroutine for conn
+func newSender(conn net.Conn, writeTimeout time.Duration) *sender {
+	s := &sender{
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		queue:        make(chan []byte, sendQueueSize),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Send queues a frame without blocking
+func (s *sender) Send(frame []byte) error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-s.done:
+		return ErrSessionClosed
+	default:
+	}
+
+	select {
+	case s.queue <- frame:
+		return nil
+	default:
+		s.fail(ErrSendQueueFull)
+		return ErrSendQueueFull
+	}
+}
+
+// Err returns the error that stopped the writer, if any
+func (s *sender) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close flushes queued frames within the write timeout and stops the writer
+func (s *sender) Close() {
+	s.once.Do(func() { close(s.done) })
+	<-s.stopped
+}
+
+// fail records the first error and closes the connection
+func (s *sender) fail(err error) {
+	s.mu.Lock()
+	first := s.err == nil
+	if first {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	if first {
+		s.conn.Close()
+	}
+}
+
+func (s *sender) run() {
+	defer close(s.stopped)
+
+	for {
+		select {
+		case frame := <-s.queue:
+			if !s.write(frame) {
+				return
+			}
+		case <-s.done:
+			// Flush what is already queued, then stop
+			for {
+				select {
+				case frame := <-s.queue:
+					if !s.write(frame) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write sends one frame under the write deadline
+func (s *sender) write(frame []byte) bool {
+	if s.Err() != nil {
+		return false
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		logger.Warn("gateway write failed, closing session", "addr", s.conn.RemoteAddr().String(), "err", err)
+		s.fail(err)
+		return false
+	}
+	logging.TracePacket(logger, "sent frame", frame, "addr", s.conn.RemoteAddr().String())
+	return true
+}
@@ -0,0 +1,401 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"jx2-paysys/internal/database"
+)
+
+// OnlinePlayer is an account logged in through a Bishop gateway
+type OnlinePlayer struct {
+	Username  string
+	SessionID string
+	Addr      string
+	LoginTime time.Time
+}
+
+// registerSession adds a live Bishop session
+func (h *Handler) registerSession(session *BishopSession) {
+	h.sessionMutex.Lock()
+	h.bishopSessions[session.ID] = session
+	h.sessionMutex.Unlock()
+}
+
+// unregisterSession removes a Bishop session and releases its players. It
+// is safe to call more than once for the same session.
+func (h *Handler) unregisterSession(sessionID string) {
+	h.sessionMutex.Lock()
+	_, exists := h.bishopSessions[sessionID]
+	delete(h.bishopSessions, sessionID)
+	released := h.releasePlayersLocked(sessionID)
+	h.sessionMutex.Unlock()
+
+	if exists {
+		logger.Info("Bishop session cleaned up", "session", sessionID, "released_players", len(released))
+	}
+	h.recordLogouts(context.Background(), released)
+}
+
+// SendToGateway queues a frame for the Bishop session with the given ID.
+// Frames are written in order by the session's writer; a write failure or a
+// full queue closes the session.
+func (h *Handler) SendToGateway(sessionID string, frame []byte) error {
+	h.sessionMutex.RLock()
+	session, exists := h.bishopSessions[sessionID]
+	h.sessionMutex.RUnlock()
+	if !exists || session.out == nil {
+		return ErrUnknownGateway
+	}
+	return session.out.Send(frame)
+}
+
+// KickPlayer tells the Bishop that owns username to disconnect the player,
+// then drops the player from the online list and stores the logout time
+func (h *Handler) KickPlayer(ctx context.Context, username string, reason uint8) error {
+	h.sessionMutex.RLock()
+	player, online := h.onlinePlayers[username]
+	h.sessionMutex.RUnlock()
+	if !online {
+		return ErrPlayerOffline
+	}
+
+	if err := h.SendToGateway(player.SessionID, CreateKickAccountRequest(username, reason)); err != nil {
+		return fmt.Errorf("failed to kick %s from %s: %w", username, player.SessionID, err)
+	}
+	logger.Info("kick sent", "username", username, "session", player.SessionID, "reason", reason)
+	h.dropPlayer(ctx, player)
+	return nil
+}
+
+// dropPlayer removes player from the online list and stores its logout
+// time. Only that entry is dropped; the account may have logged in again.
+func (h *Handler) dropPlayer(ctx context.Context, player *OnlinePlayer) {
+	h.sessionMutex.Lock()
+	current, still := h.onlinePlayers[player.Username]
+	if still && current == player {
+		delete(h.onlinePlayers, player.Username)
+	}
+	h.sessionMutex.Unlock()
+	if still && current == player {
+		h.recordLogouts(ctx, []*OnlinePlayer{player})
+	}
+}
+
+// SetProvisionalPackets enables or disables the Paysys-initiated frames
+// whose layout is still provisional. The admin kick API is not affected.
+func (h *Handler) SetProvisionalPackets(enabled bool) {
+	h.provisional.Store(enabled)
+}
+
+// NotifyCoinBalance sends an account's new coin balance to the Bishop that
+// owns it. The notice is provisional and only sent with ProvisionalPackets on.
+func (h *Handler) NotifyCoinBalance(username string, coin int64) error {
+	if !h.provisional.Load() {
+		return ErrProvisionalDisabled
+	}
+	player, online := h.FindOnlinePlayer(username)
+	if !online {
+		return ErrPlayerOffline
+	}
+	if err := h.SendToGateway(player.SessionID, CreateCoinBalanceNotice(username, coin)); err != nil {
+		return fmt.Errorf("failed to notify %s of coin balance: %w", player.SessionID, err)
+	}
+	logger.Info("coin balance sent", "username", username, "session", player.SessionID, "coin", coin)
+	return nil
+}
+
+// touchSession records traffic on a session; ping also updates LastPing
+func (h *Handler) touchSession(sessionID string, ping bool) {
+	now := time.Now()
+	h.sessionMutex.Lock()
+	if session, exists := h.bishopSessions[sessionID]; exists {
+		session.LastActivity = now
+		if ping {
+			session.LastPing = now
+		}
+	}
+	h.sessionMutex.Unlock()
+}
+
+// endRelogin closes the entry of an account that logs in again through the
+// Bishop it is already online on. The logout frame is not decoded, so the new
+// login is the first sign the earlier session ended; closing it first stops
+// its play time before the limit is checked.
+func (h *Handler) endRelogin(username, sessionID string) {
+	h.sessionMutex.RLock()
+	previous, online := h.onlinePlayers[username]
+	h.sessionMutex.RUnlock()
+	if online && previous.SessionID == sessionID {
+		logger.Debug("account logged in again on the same Bishop", "username", username, "session", sessionID)
+		h.dropPlayer(context.Background(), previous)
+	}
+}
+
+// addOnlinePlayer records a successful login made through a Bishop session.
+// If the account is still online through another Bishop, the earlier entry is
+// closed; with ProvisionalPackets on, that Bishop is also told to kick it.
+func (h *Handler) addOnlinePlayer(username, sessionID, addr string) {
+	h.sessionMutex.RLock()
+	previous, online := h.onlinePlayers[username]
+	h.sessionMutex.RUnlock()
+	if online && previous.SessionID != sessionID {
+		if h.provisional.Load() {
+			logger.Warn("duplicate login, kicking previous session", "username", username, "previous_session", previous.SessionID, "session", sessionID)
+			if err := h.KickPlayer(context.Background(), username, KickReasonDuplicateLogin); err != nil {
+				logger.Warn("failed to kick duplicate login", "username", username, "err", err)
+			}
+		} else {
+			logger.Warn("duplicate login, previous session not kicked", "username", username, "previous_session", previous.SessionID, "session", sessionID)
+			h.dropPlayer(context.Background(), previous)
+		}
+	}
+
+	h.sessionMutex.Lock()
+	defer h.sessionMutex.Unlock()
+
+	if _, exists := h.bishopSessions[sessionID]; !exists {
+		return
+	}
+	h.onlinePlayers[username] = &OnlinePlayer{
+		Username:  username,
+		SessionID: sessionID,
+		Addr:      addr,
+		LoginTime: time.Now(),
+	}
+	h.playTime.Login(username)
+}
+
+// releasePlayersLocked drops every online player owned by sessionID and
+// returns them. sessionMutex must be held.
+func (h *Handler) releasePlayersLocked(sessionID string) []*OnlinePlayer {
+	var released []*OnlinePlayer
+	for username, player := range h.onlinePlayers {
+		if player.SessionID == sessionID {
+			delete(h.onlinePlayers, username)
+			released = append(released, player)
+		}
+	}
+	return released
+}
+
+// ReleaseAll drops every online player and stores their logout time. It is
+// the last step of a shutdown, for players whose gateway did not close in
+// time.
+func (h *Handler) ReleaseAll(ctx context.Context) {
+	h.sessionMutex.Lock()
+	released := make([]*OnlinePlayer, 0, len(h.onlinePlayers))
+	for username, player := range h.onlinePlayers {
+		delete(h.onlinePlayers, username)
+		released = append(released, player)
+	}
+	h.sessionMutex.Unlock()
+
+	if len(released) > 0 {
+		logger.Info("released online players", "count", len(released))
+	}
+	h.recordLogouts(ctx, released)
+}
+
+// recordLogouts stops counting the play time of released players and
+// persists their sessions
+func (h *Handler) recordLogouts(ctx context.Context, players []*OnlinePlayer) {
+	for _, player := range players {
+		h.playTime.Logout(player.Username)
+	}
+	if len(players) == 0 || h.db == nil {
+		return
+	}
+
+	now := time.Now()
+	records := make([]database.SessionRecord, len(players))
+	for i, player := range players {
+		records[i] = database.SessionRecord{
+			Username:   player.Username,
+			Gateway:    player.SessionID,
+			LoginTime:  player.LoginTime,
+			LogoutTime: now,
+		}
+	}
+	if err := h.db.RecordSessions(ctx, records); err != nil {
+		logger.Error("failed to record logouts", "players", len(records), "err", err)
+	}
+}
+
+// GetOnlinePlayers returns a snapshot of the accounts currently online
+func (h *Handler) GetOnlinePlayers() []OnlinePlayer {
+	h.sessionMutex.RLock()
+	defer h.sessionMutex.RUnlock()
+
+	players := make([]OnlinePlayer, 0, len(h.onlinePlayers))
+	for _, player := range h.onlinePlayers {
+		players = append(players, *player)
+	}
+	return players
+}
+
+// OnlinePlayerCount returns how many accounts are online
+func (h *Handler) OnlinePlayerCount() int {
+	h.sessionMutex.RLock()
+	defer h.sessionMutex.RUnlock()
+	return len(h.onlinePlayers)
+}
+
+// GatewayCount returns how many Bishop sessions are registered
+func (h *Handler) GatewayCount() int {
+	h.sessionMutex.RLock()
+	defer h.sessionMutex.RUnlock()
+	return len(h.bishopSessions)
+}
+
+// FindOnlinePlayer returns the online entry for username, if any
+func (h *Handler) FindOnlinePlayer(username string) (OnlinePlayer, bool) {
+	h.sessionMutex.RLock()
+	defer h.sessionMutex.RUnlock()
+
+	player, online := h.onlinePlayers[username]
+	if !online {
+		return OnlinePlayer{}, false
+	}
+	return *player, true
+}
+
+// deadAfter is how long a gateway may stay silent before it is declared dead
+func (h *Handler) deadAfter() time.Duration {
+	return h.pingCycle * time.Duration(h.maxMissedPings)
+}
+
+// readDeadline is the deadline for the next read on a gateway connection,
+// cut short to the end of the grace period while draining
+func (h *Handler) readDeadline() time.Time {
+	deadline := time.Now().Add(h.deadAfter())
+	if until := h.drainUntil.Load(); until != 0 && until < deadline.UnixNano() {
+		return time.Unix(0, until)
+	}
+	return deadline
+}
+
+// BeginDrain starts a shutdown: no gateway read is allowed past until, after
+// which each session closes and its players are released. Requests that
+// arrive before then are still answered. Reads already blocked keep their
+// old deadline; the server moves those.
+func (h *Handler) BeginDrain(until time.Time) {
+	h.drainUntil.Store(until.UnixNano())
+
+	h.sessionMutex.RLock()
+	sessions, players := len(h.bishopSessions), len(h.onlinePlayers)
+	h.sessionMutex.RUnlock()
+	logger.Info("draining Bishop sessions", "sessions", sessions, "online_players", players, "grace_until", until.Format(time.RFC3339))
+}
+
+// Draining reports whether a shutdown has started
+func (h *Handler) Draining() bool {
+	return h.drainUntil.Load() != 0
+}
+
+// MonitorGateways declares a Bishop gateway dead once it has missed
+// MaxMissedPings ping cycles, closing its connection and releasing its
+// online players. It returns when ctx is cancelled.
+func (h *Handler) MonitorGateways(ctx context.Context) {
+	ticker := time.NewTicker(h.pingCycle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reapDeadGateways()
+		}
+	}
+}
+
+// reapDeadGateways closes every session whose last ping is too old
+func (h *Handler) reapDeadGateways() {
+	cutoff := time.Now().Add(-h.deadAfter())
+
+	h.sessionMutex.Lock()
+	var dead []*BishopSession
+	for id, session := range h.bishopSessions {
+		if session.LastPing.Before(cutoff) {
+			dead = append(dead, session)
+			delete(h.bishopSessions, id)
+		}
+	}
+	released := make(map[string][]*OnlinePlayer, len(dead))
+	for _, session := range dead {
+		released[session.ID] = h.releasePlayersLocked(session.ID)
+	}
+	h.sessionMutex.Unlock()
+
+	for _, session := range dead {
+		logger.Warn("Bishop gateway dead, missed ping cycles",
+			"session", session.ID,
+			"last_ping", session.LastPing.Format(time.RFC3339),
+			"missed", h.maxMissedPings,
+			"released_players", len(released[session.ID]))
+		if session.Conn != nil {
+			session.Conn.Close()
+		}
+		h.recordLogouts(context.Background(), released[session.ID])
+	}
+}
+
+// PlayTime returns the online time counted today for username
+func (h *Handler) PlayTime(username string) time.Duration {
+	return h.playTime.Used(username)
+}
+
+// playTimeCheckInterval is how often online players are checked against the
+// daily play-time limit
+const playTimeCheckInterval = 15 * time.Second
+
+// MonitorPlayTime kicks players who have used up their daily play time. The
+// kick is provisional; with ProvisionalPackets off such players are logged
+// once and left online. It returns when ctx is cancelled.
+func (h *Handler) MonitorPlayTime(ctx context.Context) {
+	if h.playTime == nil {
+		return
+	}
+
+	ticker := time.NewTicker(playTimeCheckInterval)
+	defer ticker.Stop()
+
+	// Players already reported as over the limit but not kicked
+	reported := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			exceeded := h.playTime.Check(ctx)
+			if !h.provisional.Load() {
+				next := make(map[string]bool, len(exceeded))
+				for _, username := range exceeded {
+					if !reported[username] {
+						logger.Warn("daily play time used up, player not kicked", "username", username, "used", h.playTime.Used(username).Round(time.Second))
+					}
+					next[username] = true
+				}
+				reported = next
+				continue
+			}
+			reported = make(map[string]bool)
+			for _, username := range exceeded {
+				logger.Info("daily play time used up, kicking player", "username", username, "used", h.playTime.Used(username).Round(time.Second))
+				err := h.KickPlayer(ctx, username, KickReasonPlayTimeLimit)
+				switch {
+				case err == nil:
+					playTimeKicksTotal.Inc()
+				case errors.Is(err, ErrPlayerOffline):
+					// Released in the meantime; make sure the count stops
+					h.playTime.Logout(username)
+				default:
+					logger.Warn("failed to kick player over play time", "username", username, "err", err)
+				}
+			}
+		}
+	}
+}
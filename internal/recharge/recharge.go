@@ -0,0 +1,284 @@
+// Package recharge credits coin bought in the web shop. Orders arrive as
+// signed CSV or JSON files or, one at a time, from the shop's webhook; each
+// is applied through the coin ledger with the shop's order id as reference,
+// so an order is credited at most once however often it is delivered.
+package recharge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/logging"
+)
+
+var logger = logging.For("recharge")
+
+// MaxOrderIDLength is the size of coin_ledger.reference
+const MaxOrderIDLength = 64
+
+var (
+	// ErrNoSecret is returned when no signing key is configured
+	ErrNoSecret = errors.New("recharge secret is not configured")
+	// ErrBadSignature is returned when a file or request is not signed with
+	// the configured key
+	ErrBadSignature = errors.New("invalid signature")
+)
+
+// Store credits coin through the ledger
+type Store interface {
+	ApplyCoin(ctx context.Context, actor string, ch database.CoinChange) (int64, error)
+}
+
+// Order is one paid shop order
+type Order struct {
+	OrderID  string `json:"order_id"`
+	Username string `json:"account"`
+	Amount   int64  `json:"amount"`
+	Line     int    `json:"line,omitempty"` // Position in the file, for reports
+}
+
+// Validate checks the fields before anything is applied
+func (o Order) Validate() error {
+	if o.OrderID == "" || len(o.OrderID) > MaxOrderIDLength {
+		return fmt.Errorf("order id must be 1 to %d characters", MaxOrderIDLength)
+	}
+	for _, r := range o.OrderID {
+		if r <= ' ' || r > '~' {
+			return errors.New("order id must be printable ASCII without spaces")
+		}
+	}
+	if err := database.ValidateUsername(o.Username); err != nil {
+		return err
+	}
+	if o.Amount <= 0 {
+		return fmt.Errorf("amount must be positive, got %d", o.Amount)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of data under secret, as the shop
+// computes it
+func Sign(secret string, data []byte) string {
+	return hex.EncodeToString(sum(secret, data))
+}
+
+func sum(secret string, data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Verify checks a hex HMAC-SHA256 signature of data. A "sha256=" prefix and
+// surrounding whitespace are accepted.
+func Verify(secret string, data []byte, signature string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, sum(secret, data)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ParseFile reads orders from a recharge file, choosing the format by the
+// name's extension: .csv or .json
+func ParseFile(name string, data []byte) ([]Order, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return ParseCSV(data)
+	case ".json":
+		return ParseJSON(data)
+	}
+	return nil, fmt.Errorf("unknown recharge file type %q, want .csv or .json", filepath.Ext(name))
+}
+
+// csvColumns are the required CSV header names
+var csvColumns = []string{"order_id", "account", "amount"}
+
+// ParseCSV reads a CSV file with an order_id,account,amount header in any
+// column order. Lines starting with # are comments.
+func ParseCSV(data []byte) ([]Order, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column", name)
+		}
+	}
+
+	var orders []Order
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		amount, err := strconv.ParseInt(strings.TrimSpace(record[index["amount"]]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[index["amount"]])
+		}
+		orders = append(orders, Order{
+			OrderID:  strings.TrimSpace(record[index["order_id"]]),
+			Username: strings.TrimSpace(record[index["account"]]),
+			Amount:   amount,
+			Line:     line,
+		})
+	}
+	return orders, nil
+}
+
+// ParseJSON reads a JSON array of {"order_id", "account", "amount"} objects
+func ParseJSON(data []byte) ([]Order, error) {
+	var orders []Order
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&orders); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	for i := range orders {
+		orders[i].Line = i + 1
+	}
+	return orders, nil
+}
+
+// Result statuses
+const (
+	StatusApplied        = "applied"
+	StatusDuplicate      = "duplicate"       // Order id already credited
+	StatusUnknownAccount = "unknown_account" // No such username
+	StatusInvalid        = "invalid"         // Failed Validate
+	StatusFailed         = "failed"          // Store error, safe to retry
+)
+
+// Result is the outcome of one order
+type Result struct {
+	Order
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Balance int64  `json:"balance,omitempty"` // Coin after the credit
+}
+
+// Credit applies one order and reports what happened. An order id that was
+// already credited is a duplicate, not an error, so deliveries can be
+// retried safely.
+func Credit(ctx context.Context, store Store, actor string, o Order) Result {
+	res := Result{Order: o}
+	if err := o.Validate(); err != nil {
+		res.Status, res.Reason = StatusInvalid, err.Error()
+		return res
+	}
+
+	balance, err := store.ApplyCoin(ctx, actor, database.CoinChange{
+		Username:  o.Username,
+		Amount:    o.Amount,
+		Kind:      database.LedgerRecharge,
+		Reference: o.OrderID,
+	})
+	switch {
+	case err == nil:
+		res.Status, res.Balance = StatusApplied, balance
+		logger.Info("recharge credited", "order_id", o.OrderID, "username", o.Username, "amount", o.Amount, "coin", balance)
+	case errors.Is(err, database.ErrDuplicateReference):
+		res.Status, res.Reason = StatusDuplicate, "order already credited"
+	case errors.Is(err, database.ErrAccountNotFound):
+		res.Status, res.Reason = StatusUnknownAccount, err.Error()
+	default:
+		res.Status, res.Reason = StatusFailed, err.Error()
+		logger.Error("recharge failed", "order_id", o.OrderID, "username", o.Username, "err", err)
+	}
+	return res
+}
+
+// Report is the outcome of importing one file. Duplicates are orders that
+// were already credited; they are not counted as Rejected, so importing a
+// file again is not a failure.
+type Report struct {
+	File       string    `json:"file"`
+	Time       time.Time `json:"time"`
+	Applied    int       `json:"applied"`
+	Duplicates int       `json:"duplicates"`
+	Rejected   int       `json:"rejected"`
+	Coin       int64     `json:"coin"` // Total credited
+	Results    []Result  `json:"results"`
+}
+
+// Import credits every order in turn. An order id repeated within the file
+// is reported as a duplicate without touching the store.
+func Import(ctx context.Context, store Store, actor, file string, orders []Order) *Report {
+	report := &Report{File: file, Time: time.Now(), Results: []Result{}}
+	seen := make(map[string]int)
+	for _, o := range orders {
+		var res Result
+		if first, ok := seen[o.OrderID]; ok && o.OrderID != "" {
+			res = Result{Order: o, Status: StatusDuplicate, Reason: fmt.Sprintf("repeats line %d", first)}
+		} else {
+			seen[o.OrderID] = o.Line
+			res = Credit(ctx, store, actor, o)
+		}
+
+		switch res.Status {
+		case StatusApplied:
+			report.Applied++
+			report.Coin += o.Amount
+		case StatusDuplicate:
+			report.Duplicates++
+		default:
+			report.Rejected++
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per order with a header row
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"line", "order_id", "account", "amount", "status", "reason", "balance"})
+	for _, res := range r.Results {
+		balance := ""
+		if res.Status == StatusApplied {
+			balance = strconv.FormatInt(res.Balance, 10)
+		}
+		cw.Write([]string{
+			strconv.Itoa(res.Line), res.OrderID, res.Username, strconv.FormatInt(res.Amount, 10),
+			res.Status, res.Reason, balance,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,123 @@
+package recharge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"jx2-paysys/internal/database"
+)
+
+// fakeStore credits known accounts once per reference, like the ledger's
+// unique (kind, reference) key
+type fakeStore struct {
+	coin    map[string]int64
+	applied map[string]bool
+	fail    error // Returned by every ApplyCoin when set
+	calls   int
+}
+
+func newFakeStore(accounts ...string) *fakeStore {
+	s := &fakeStore{coin: make(map[string]int64), applied: make(map[string]bool)}
+	for _, username := range accounts {
+		s.coin[username] = 0
+	}
+	return s
+}
+
+func (s *fakeStore) ApplyCoin(ctx context.Context, actor string, ch database.CoinChange) (int64, error) {
+	s.calls++
+	if s.fail != nil {
+		return 0, s.fail
+	}
+	if s.applied[ch.Reference] {
+		return 0, database.ErrDuplicateReference
+	}
+	coin, ok := s.coin[ch.Username]
+	if !ok {
+		return 0, database.ErrAccountNotFound
+	}
+	s.applied[ch.Reference] = true
+	s.coin[ch.Username] = coin + ch.Amount
+	return s.coin[ch.Username], nil
+}
+
+func TestImport(t *testing.T) {
+	orders := []Order{
+		{OrderID: "SHOP-1", Username: "alice", Amount: 500, Line: 2},
+		{OrderID: "SHOP-2", Username: "bob", Amount: 1000, Line: 3},
+		{OrderID: "SHOP-1", Username: "alice", Amount: 500, Line: 4},
+		{OrderID: "SHOP-3", Username: "nobody", Amount: 10, Line: 5},
+		{OrderID: "SHOP-4", Username: "alice", Amount: 0, Line: 6},
+	}
+	store := newFakeStore("alice", "bob")
+	ctx := context.Background()
+
+	report := Import(ctx, store, "test", "orders.csv", orders)
+	want := []string{StatusApplied, StatusApplied, StatusDuplicate, StatusUnknownAccount, StatusInvalid}
+	if len(report.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(report.Results), len(want))
+	}
+	for i, res := range report.Results {
+		if res.Status != want[i] {
+			t.Errorf("line %d: status %q, want %q (%s)", res.Line, res.Status, want[i], res.Reason)
+		}
+	}
+	if report.Results[2].Reason != "repeats line 2" {
+		t.Errorf("repeated order reason = %q", report.Results[2].Reason)
+	}
+	if report.Applied != 2 || report.Duplicates != 1 || report.Rejected != 2 || report.Coin != 1500 {
+		t.Errorf("report counts applied=%d duplicates=%d rejected=%d coin=%d, want 2, 1, 2, 1500",
+			report.Applied, report.Duplicates, report.Rejected, report.Coin)
+	}
+	// The repeat within the file and the invalid order never reach the store
+	if store.calls != 3 {
+		t.Errorf("store called %d times, want 3", store.calls)
+	}
+
+	// A second import of the same file credits nothing and rejects nothing new
+	again := Import(ctx, store, "test", "orders.csv", orders[:3])
+	if again.Applied != 0 || again.Duplicates != 3 || again.Rejected != 0 || again.Coin != 0 {
+		t.Errorf("re-import counts applied=%d duplicates=%d rejected=%d coin=%d, want 0, 3, 0, 0",
+			again.Applied, again.Duplicates, again.Rejected, again.Coin)
+	}
+	if store.coin["alice"] != 500 || store.coin["bob"] != 1000 {
+		t.Errorf("balances alice=%d bob=%d, want 500 and 1000", store.coin["alice"], store.coin["bob"])
+	}
+}
+
+func TestImportStoreFailure(t *testing.T) {
+	store := newFakeStore("alice")
+	store.fail = errors.New("connection refused")
+	report := Import(context.Background(), store, "test", "orders.csv", []Order{
+		{OrderID: "SHOP-1", Username: "alice", Amount: 500, Line: 2},
+	})
+	if report.Rejected != 1 || report.Results[0].Status != StatusFailed {
+		t.Errorf("store failure: rejected=%d status=%q, want 1 and %q", report.Rejected, report.Results[0].Status, StatusFailed)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("order_id,account,amount\nSHOP-1,alice,500\n")
+	sig := Sign("secret", data)
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		want      error
+	}{
+		{"valid", "secret", sig, nil},
+		{"prefix and newline", "secret", "sha256=" + sig + "\n", nil},
+		{"other key", "other", sig, ErrBadSignature},
+		{"empty signature", "secret", "", ErrBadSignature},
+		{"not hex", "secret", "xyz", ErrBadSignature},
+		{"no secret", "", sig, ErrNoSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Verify(tt.secret, data, tt.signature); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Package reconcile compares account coin balances with the coin ledger. It
+// runs as a periodic job inside paysys, which only reports, and from
+// "paysys account reconcile", which can also repair.
+package reconcile
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"jx2-paysys/internal/config"
+	"jx2-paysys/internal/database"
+	"jx2-paysys/internal/logging"
+)
+
+var logger = logging.For("reconcile")
+
+// Store is the part of the account database a reconciliation needs
+type Store interface {
+	CoinDrifts(ctx context.Context) ([]database.CoinDrift, error)
+	SuspectedDoubleCharges(ctx context.Context, since time.Time, window time.Duration) ([]database.DoubleCharge, error)
+	RepairCoinDrift(ctx context.Context, actor, username string, accept bool) (database.CoinDrift, error)
+}
+
+// Repair modes
+const (
+	RepairNone   = ""       // Report only
+	RepairLedger = "ledger" // Reset coin to the ledger balance
+	RepairAccept = "accept" // Keep coin and record the difference in the ledger
+)
+
+// Options select what a reconciliation checks and fixes
+type Options struct {
+	Since  time.Time     // Earliest debit considered for double charges
+	Window time.Duration // Largest gap between double charges, 0 skips the check
+	Repair string        // Repair* mode
+	Actor  string        // Audit actor for repairs
+}
+
+// Drift is one account out of line with the ledger and what was done about it
+type Drift struct {
+	database.CoinDrift
+	Repaired string `json:"repaired,omitempty"` // Repair mode applied
+	Error    string `json:"error,omitempty"`    // Repair failure
+}
+
+// Report is the result of one reconciliation
+type Report struct {
+	Time          time.Time               `json:"time"`
+	Drifts        []Drift                 `json:"drifts"`
+	DoubleCharges []database.DoubleCharge `json:"double_charges"`
+}
+
+// Clean reports whether nothing was found
+func (r *Report) Clean() bool {
+	return len(r.Drifts) == 0 && len(r.DoubleCharges) == 0
+}
+
+// Run checks every account against the ledger, looks for double charges and
+// applies opts.Repair to each drifting account. A failed repair is recorded
+// on its Drift rather than stopping the run.
+func Run(ctx context.Context, store Store, opts Options) (*Report, error) {
+	report := &Report{Time: time.Now(), Drifts: []Drift{}, DoubleCharges: []database.DoubleCharge{}}
+
+	drifts, err := store.CoinDrifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drifts {
+		entry := Drift{CoinDrift: d}
+		if opts.Repair != RepairNone {
+			fixed, err := store.RepairCoinDrift(ctx, opts.Actor, d.Username, opts.Repair == RepairAccept)
+			switch {
+			case err != nil:
+				entry.Error = err.Error()
+			case fixed.Drift != 0:
+				// Re-read under lock; the balance may have moved since the scan
+				entry.CoinDrift = fixed
+				entry.Repaired = opts.Repair
+			}
+		}
+		report.Drifts = append(report.Drifts, entry)
+	}
+
+	if opts.Window > 0 {
+		charges, err := store.SuspectedDoubleCharges(ctx, opts.Since, opts.Window)
+		if err != nil {
+			return nil, err
+		}
+		report.DoubleCharges = append(report.DoubleCharges, charges...)
+	}
+	return report, nil
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// csvHeader is the single table WriteCSV emits; check is "drift" or
+// "double_charge" and selects which columns are filled
+var csvHeader = []string{
+	"check", "username", "coin", "expected", "drift", "entries", "repaired", "error",
+	"kind", "delta", "first_id", "first_time", "second_id", "second_time",
+}
+
+// WriteCSV writes the report as one CSV table with a header row
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	for _, d := range r.Drifts {
+		cw.Write([]string{
+			"drift", d.Username, itoa(d.Coin), itoa(d.Expected), itoa(d.Drift), itoa(d.Entries), d.Repaired, d.Error,
+			"", "", "", "", "", "",
+		})
+	}
+	for _, c := range r.DoubleCharges {
+		cw.Write([]string{
+			"double_charge", c.Username, "", "", "", "", "", "",
+			c.Kind, itoa(c.Delta), itoa(c.FirstID), c.FirstTime.Format(time.RFC3339), itoa(c.SecondID), c.SecondTime.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Job runs a report-only reconciliation every Interval seconds and logs
+// what it finds
+type Job struct {
+	store Store
+
+	mu   sync.Mutex
+	cfg  config.ReconcileConfig
+	last *Report
+}
+
+// NewJob creates a reconciliation job; Run starts it
+func NewJob(cfg config.ReconcileConfig, store Store) *Job {
+	return &Job{cfg: cfg, store: store}
+}
+
+// SetConfig replaces the interval and window from the next run on
+func (j *Job) SetConfig(cfg config.ReconcileConfig) {
+	j.mu.Lock()
+	j.cfg = cfg
+	j.mu.Unlock()
+}
+
+func (j *Job) config() config.ReconcileConfig {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cfg
+}
+
+// Last returns the most recent report, nil before the first run or on a nil
+// job
+func (j *Job) Last() *Report {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.last
+}
+
+// idlePoll is how often a disabled job checks whether a reload enabled it
+const idlePoll = time.Minute
+
+// Run reconciles on the configured interval until ctx is cancelled
+func (j *Job) Run(ctx context.Context) {
+	var lastRun time.Time
+	for {
+		wait := idlePoll
+		if cfg := j.config(); cfg.Interval > 0 {
+			interval := time.Duration(cfg.Interval) * time.Second
+			if wait = time.Until(lastRun.Add(interval)); wait <= 0 {
+				lastRun = j.runOnce(ctx, cfg, lastRun)
+				wait = interval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runOnce reconciles once and returns the time of the run. Double charges
+// are looked for since the previous run, overlapped by the window so pairs
+// straddling two runs are not missed.
+func (j *Job) runOnce(ctx context.Context, cfg config.ReconcileConfig, previous time.Time) time.Time {
+	window := time.Duration(cfg.DoubleChargeWindow) * time.Second
+	since := previous.Add(-window)
+	if previous.IsZero() {
+		since = time.Now().Add(-time.Duration(cfg.Interval)*time.Second - window)
+	}
+
+	report, err := Run(ctx, j.store, Options{Since: since, Window: window})
+	if err != nil {
+		logger.Error("coin reconciliation failed", "err", err)
+		return time.Now()
+	}
+	j.mu.Lock()
+	j.last = report
+	j.mu.Unlock()
+
+	for _, d := range report.Drifts {
+		logger.Warn("coin balance drift", "username", d.Username, "coin", d.Coin, "expected", d.Expected, "drift", d.Drift)
+	}
+	for _, c := range report.DoubleCharges {
+		logger.Warn("suspected double charge", "username", c.Username, "kind", c.Kind, "delta", c.Delta,
+			"first_id", c.FirstID, "second_id", c.SecondID, "gap", c.SecondTime.Sub(c.FirstTime))
+	}
+	if report.Clean() {
+		logger.Info("coin reconciliation clean")
+	}
+	return report.Time
+}
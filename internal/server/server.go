@@ -1,32 +1,91 @@
 package server
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"jx2-paysys/internal/ipfilter"
+	"jx2-paysys/internal/logging"
 	"jx2-paysys/internal/protocol"
 )
 
+var logger = logging.For("server")
+
+// Limits bounds the resources the server hands out to gateways. Zero values
+// mean unlimited or the OS default.
+type Limits struct {
+	// MaxConnections caps concurrently served connections; further
+	// connections are closed straight after accept
+	MaxConnections int
+	// RecvBufSize and SendBufSize set the kernel socket buffers per
+	// connection (the original nMaxRecvBufSizePerSocket and
+	// nMaxSendBufSizePerSocket)
+	RecvBufSize int
+	SendBufSize int
+}
+
 // PaysysServer represents the main paysys server
 type PaysysServer struct {
 	ip       string
 	port     int
+	gateways atomic.Pointer[ipfilter.Filter]
+	limits   atomic.Pointer[Limits]
 	listener net.Listener
 	handler  *protocol.Handler
 	wg       sync.WaitGroup
 	shutdown chan struct{}
+	stopOnce sync.Once
+	connsMu  sync.Mutex
+	conns    map[net.Conn]struct{}
+	rejected atomic.Uint64
+	refused  atomic.Uint64
+	active   atomic.Int64
 }
 
-// NewPaysysServer creates a new paysys server instance
-func NewPaysysServer(ip string, port int, handler *protocol.Handler) *PaysysServer {
-	return &PaysysServer{
+// NewPaysysServer creates a new paysys server instance. Only sources allowed
+// by gateways (the InternalIPMask list) may connect; an empty filter allows all.
+func NewPaysysServer(ip string, port int, gateways *ipfilter.Filter, limits Limits, handler *protocol.Handler) *PaysysServer {
+	s := &PaysysServer{
 		ip:       ip,
 		port:     port,
 		handler:  handler,
 		shutdown: make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
 	}
+	s.SetGateways(gateways)
+	s.SetLimits(limits)
+	return s
+}
+
+// SetGateways replaces the gateway allow-list; it applies to new connections
+func (s *PaysysServer) SetGateways(gateways *ipfilter.Filter) {
+	s.gateways.Store(gateways)
+}
+
+// SetLimits replaces the connection limits; they apply to new connections
+func (s *PaysysServer) SetLimits(limits Limits) {
+	s.limits.Store(&limits)
+}
+
+// RejectedConnections returns how many connections were refused by the
+// gateway allow-list
+func (s *PaysysServer) RejectedConnections() uint64 {
+	return s.rejected.Load()
+}
+
+// RefusedConnections returns how many connections were refused because
+// MaxConnections was reached
+func (s *PaysysServer) RefusedConnections() uint64 {
+	return s.refused.Load()
+}
+
+// ActiveConnections returns the number of connections being served
+func (s *PaysysServer) ActiveConnections() int64 {
+	return s.active.Load()
 }
 
 // Start starts the paysys server
@@ -39,7 +98,7 @@ func (s *PaysysServer) Start() error {
 	}
 	
 	s.listener = listener
-	log.Printf("[Server] Listening on %s", address)
+	logger.Info("listening", "addr", address)
 	
 	// Accept connections
 	for {
@@ -53,33 +112,137 @@ func (s *PaysysServer) Start() error {
 				case <-s.shutdown:
 					return nil
 				default:
-					log.Printf("[Server] Error accepting connection: %v", err)
+					logger.Warn("error accepting connection", "err", err)
 					continue
 				}
 			}
 			
+			// Only trusted gateways may talk to paysys
+			remote := conn.RemoteAddr().String()
+			if !s.gateways.Load().Allow(remote) {
+				total := s.rejected.Add(1)
+				logger.Warn("rejected connection outside InternalIPMask", "addr", remote, "rejected_total", total)
+				conn.Close()
+				continue
+			}
+			
+			// Back-pressure: refuse rather than queue once the cap is reached
+			limits := s.limits.Load()
+			active := s.active.Add(1)
+			if limits.MaxConnections > 0 && active > int64(limits.MaxConnections) {
+				s.active.Add(-1)
+				total := s.refused.Add(1)
+				logger.Warn("refused connection, MaxConnections reached", "addr", remote, "max", limits.MaxConnections, "refused_total", total)
+				conn.Close()
+				continue
+			}
+			applyBufferLimits(conn, limits)
+			
 			// Handle connection in a goroutine
+			s.track(conn, true)
 			s.wg.Add(1)
 			go func() {
 				defer s.wg.Done()
+				defer s.active.Add(-1)
+				defer s.track(conn, false)
 				s.handler.HandleConnection(conn)
 			}()
 		}
 	}
 }
 
-// Stop stops the paysys server
-func (s *PaysysServer) Stop() {
-	log.Println("[Server] Shutting down...")
-	
-	close(s.shutdown)
-	
+// applyBufferLimits sets the per-socket kernel buffer sizes
+func applyBufferLimits(conn net.Conn, limits *Limits) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if limits.RecvBufSize > 0 {
+		if err := tcpConn.SetReadBuffer(limits.RecvBufSize); err != nil {
+			logger.Warn("failed to set receive buffer", "addr", conn.RemoteAddr().String(), "err", err)
+		}
+	}
+	if limits.SendBufSize > 0 {
+		if err := tcpConn.SetWriteBuffer(limits.SendBufSize); err != nil {
+			logger.Warn("failed to set send buffer", "addr", conn.RemoteAddr().String(), "err", err)
+		}
+	}
+}
+
+// track adds or removes a served connection
+func (s *PaysysServer) track(conn net.Conn, add bool) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// eachConn calls fn for every served connection
+func (s *PaysysServer) eachConn(fn func(net.Conn)) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for conn := range s.conns {
+		fn(conn)
+	}
+}
+
+// closeWait bounds how long Shutdown waits for connection handlers to return
+// after it has closed their connections
+const closeWait = 2 * time.Second
+
+// Shutdown stops accepting, lets gateways finish in-flight requests for
+// grace, then closes their sessions, which releases their online players and
+// stores their logout times. If ctx expires first the remaining connections
+// are closed at once, their handlers get closeWait to finish, and ctx's error
+// is returned.
+func (s *PaysysServer) Shutdown(ctx context.Context, grace time.Duration) error {
+	logger.Info("shutting down", "grace", grace)
+
+	s.stopOnce.Do(func() { close(s.shutdown) })
 	if s.listener != nil {
 		s.listener.Close()
 	}
-	
-	// Wait for all connections to finish
-	s.wg.Wait()
-	
-	log.Println("[Server] Shutdown complete")
-}
\ No newline at end of file
+
+	// Gateways notice the shutdown when their connection closes at the end
+	// of the grace period; blocked reads are moved to that deadline. No
+	// shutdown notice is sent: the Bishop's own message for it has not been
+	// identified in a capture.
+	until := time.Now().Add(grace)
+	s.handler.BeginDrain(until)
+	s.eachConn(func(conn net.Conn) { conn.SetReadDeadline(until) })
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		logger.Warn("shutdown timeout, closing remaining connections", "active", s.active.Load())
+		s.eachConn(func(conn net.Conn) { conn.Close() })
+
+		// Handlers may still be recording logouts; let them finish before
+		// the rest are released and the caller closes the store
+		select {
+		case <-done:
+		case <-time.After(closeWait):
+			logger.Warn("connection handlers still running after close", "active", s.active.Load())
+		}
+	}
+
+	s.handler.ReleaseAll(context.WithoutCancel(ctx))
+	logger.Info("shutdown complete")
+	return err
+}
+
+// Stop stops the paysys server without a grace period
+func (s *PaysysServer) Stop() {
+	s.Shutdown(context.Background(), 0)
+}
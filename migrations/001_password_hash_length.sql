@@ -0,0 +1,3 @@
+-- Widen account.password for bcrypt (60 chars) and argon2id (~97 chars) hashes.
+-- Legacy 32-char MD5 values are upgraded in place on the next successful login.
+ALTER TABLE `account` MODIFY `password` varchar(128) NOT NULL;
@@ -0,0 +1,11 @@
+-- One row per finished player session, written when the player is released:
+-- gateway death, gateway disconnect or paysys shutdown.
+CREATE TABLE IF NOT EXISTS `account_session` (
+  `id` bigint(20) NOT NULL auto_increment,
+  `username` varchar(32) NOT NULL,
+  `gateway` varchar(64) NOT NULL,
+  `login_time` datetime NOT NULL,
+  `logout_time` datetime NOT NULL,
+  PRIMARY KEY  (`id`),
+  KEY `username_logout` (`username`, `logout_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,14 @@
+-- One row per field changed on an account by the GM tools, written in the
+-- same transaction as the change. Secret values are stored as <redacted>.
+CREATE TABLE IF NOT EXISTS `account_audit` (
+  `id` bigint(20) NOT NULL auto_increment,
+  `created_at` datetime NOT NULL default CURRENT_TIMESTAMP,
+  `actor` varchar(64) NOT NULL,
+  `username` varchar(32) NOT NULL,
+  `action` varchar(32) NOT NULL,
+  `field` varchar(32) NOT NULL default '',
+  `old_value` varchar(64) NOT NULL default '',
+  `new_value` varchar(64) NOT NULL default '',
+  PRIMARY KEY  (`id`),
+  KEY `username_id` (`username`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
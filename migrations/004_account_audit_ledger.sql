@@ -0,0 +1,13 @@
+-- Turn account_audit into an append-only ledger covering every account
+-- mutation. Rows written by one transaction share txn_id.
+ALTER TABLE `account_audit`
+  ADD COLUMN `txn_id` char(32) NOT NULL default '' AFTER `id`,
+  ADD KEY `txn_id` (`txn_id`),
+  ADD KEY `username_field` (`username`, `field`, `id`);
+
+-- Refuse edits and deletes, even from the paysys user. For stronger
+-- guarantees also grant the paysys user only SELECT and INSERT on this table.
+CREATE TRIGGER `account_audit_no_update` BEFORE UPDATE ON `account_audit`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'account_audit is append-only';
+CREATE TRIGGER `account_audit_no_delete` BEFORE DELETE ON `account_audit`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'account_audit is append-only';
@@ -0,0 +1,30 @@
+-- One row per credit or debit of account.coin, written in the same
+-- transaction as the balance change. The sum of delta per account is the
+-- balance the ledger expects; "paysys account reconcile" compares the two.
+-- reference carries an external id such as a shop order id and is unique per
+-- kind, so replayed credits are rejected.
+CREATE TABLE IF NOT EXISTS `coin_ledger` (
+  `id` bigint(20) NOT NULL auto_increment,
+  `txn_id` char(32) NOT NULL,
+  `created_at` datetime NOT NULL default CURRENT_TIMESTAMP,
+  `actor` varchar(64) NOT NULL,
+  `username` varchar(32) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `reference` varchar(64) default NULL,
+  `delta` bigint(20) NOT NULL,
+  `balance` bigint(20) NOT NULL,
+  PRIMARY KEY  (`id`),
+  UNIQUE KEY `kind_reference` (`kind`, `reference`),
+  KEY `username_id` (`username`, `id`),
+  KEY `created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- Existing balances become each account's opening entry
+INSERT INTO `coin_ledger` (`txn_id`, `actor`, `username`, `kind`, `delta`, `balance`)
+  SELECT 'opening', 'system:migration', `username`, 'opening', `coin`, `coin`
+  FROM `account` WHERE `coin` <> 0;
+
+CREATE TRIGGER `coin_ledger_no_update` BEFORE UPDATE ON `coin_ledger`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'coin_ledger is append-only';
+CREATE TRIGGER `coin_ledger_no_delete` BEFORE DELETE ON `coin_ledger`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'coin_ledger is append-only';